@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is the config file looked up in the watch directory when -config is not set
+const defaultConfigFile = ".gotestwatcher.yml"
+
+// config holds the options that can be committed to a repository in a config file.
+// Each option has the same meaning as the command line flag it sets.
+type config struct {
+	// Debounce sets -d, e.g. "1s"
+	Debounce *string `yaml:"debounce"`
+	// Filter sets -f
+	Filter []string `yaml:"filter"`
+	// Exclude sets -exclude
+	Exclude []string `yaml:"exclude"`
+	// Coverage sets -c
+	Coverage *bool `yaml:"coverage"`
+	// Race sets -race
+	Race *bool `yaml:"race"`
+	// Command sets -cmd, e.g. "gotestsum --"
+	Command *string `yaml:"command"`
+}
+
+// loadConfig reads the config file at path. A missing file is only an error when required is set.
+func loadConfig(path string, required bool) (*config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	var cfg config
+	if len(doc.Content) == 0 {
+		// An empty file sets nothing
+		return &cfg, nil
+	}
+
+	root := doc.Content[0]
+	if err := validateConfig(root); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := root.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// configKeys returns the options a config file may set
+func configKeys() []string {
+	configType := reflect.TypeFor[config]()
+	keys := make([]string, 0, configType.NumField())
+	for i := range configType.NumField() {
+		keys = append(keys, configType.Field(i).Tag.Get("yaml"))
+	}
+	return keys
+}
+
+// validateConfig checks the options of a config file for mistakes that would otherwise go unnoticed,
+// such as a misspelled option or a glob pattern that never matches, reporting the line of the mistake
+func validateConfig(root *yaml.Node) error {
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected options as \"key: value\" lines", root.Line)
+	}
+
+	keys := configKeys()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		switch key.Value {
+		case "debounce":
+			if _, err := time.ParseDuration(value.Value); value.Kind == yaml.ScalarNode && err != nil {
+				return fmt.Errorf("line %d: invalid debounce %q, expected a duration such as 500ms or 1s", value.Line, value.Value)
+			}
+		case "filter", "exclude":
+			for _, item := range value.Content {
+				if err := checkPattern(item.Value); item.Kind == yaml.ScalarNode && err != nil {
+					return fmt.Errorf("line %d: %s: %w", item.Line, key.Value, err)
+				}
+			}
+		default:
+			if !slices.Contains(keys, key.Value) {
+				return fmt.Errorf("line %d: unknown option %q, expected one of %s", key.Line, key.Value, strings.Join(keys, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// configPath returns the config file to load and whether it was requested explicitly
+func configPath(configFlag, dirFlag string) (string, bool) {
+	if configFlag != "" {
+		return configFlag, true
+	}
+	dirs := splitList(dirFlag)
+	if len(dirs) == 0 {
+		return defaultConfigFile, false
+	}
+	return filepath.Join(dirs[0], defaultConfigFile), false
+}
+
+// applyConfig sets the flags configured in cfg, leaving flags given on the command line untouched
+func applyConfig(flags *flag.FlagSet, cfg *config) error {
+	values := make(map[string]string)
+	if cfg.Debounce != nil {
+		values["d"] = *cfg.Debounce
+	}
+	if cfg.Filter != nil {
+		values["f"] = strings.Join(cfg.Filter, ",")
+	}
+	if cfg.Exclude != nil {
+		values["exclude"] = strings.Join(cfg.Exclude, ",")
+	}
+	if cfg.Coverage != nil {
+		values["c"] = strconv.FormatBool(*cfg.Coverage)
+	}
+	if cfg.Race != nil {
+		values["race"] = strconv.FormatBool(*cfg.Race)
+	}
+	if cfg.Command != nil {
+		values["cmd"] = *cfg.Command
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		delete(values, f.Name)
+	})
+
+	for name, value := range values {
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config value for -%s: %w", name, err)
+		}
+	}
+	return nil
+}
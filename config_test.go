@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigReportsLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown key",
+			content: "debounce: 1s\nrase: true\n",
+			want:    `line 2: unknown option "rase"`,
+		},
+		{
+			name:    "bad glob",
+			content: "filter:\n  - \"*.go\"\n  - \"[.go\"\n",
+			want:    "line 3: filter:",
+		},
+		{
+			name:    "type error",
+			content: "coverage: true\n\nrace: maybe\n",
+			want:    "line 3: cannot unmarshal",
+		},
+		{
+			name:    "bad duration",
+			content: "debounce: soon\n",
+			want:    `line 1: invalid debounce "soon"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), defaultConfigFile)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadConfig(path, true)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigKeepsCommandLineFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultConfigFile)
+	content := "debounce: 1s\nfilter: [\"*.go\", \"*.tmpl\"]\nrace: true\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("go-test-watcher", flag.ContinueOnError)
+	debounce := flags.Duration("d", 0, "")
+	filter := flags.String("f", "", "")
+	race := flags.Bool("race", false, "")
+	if err := flags.Parse([]string{"-d", "200ms"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyConfig(flags, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if *debounce != 200*time.Millisecond || *filter != "*.go,*.tmpl" || !*race {
+		t.Errorf("flags after applyConfig() = -d %v -f %q -race %v, want -d 200ms -f \"*.go,*.tmpl\" -race true", *debounce, *filter, *race)
+	}
+}
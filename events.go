@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bond-kaneko/go-test-watcher/filenotify"
+)
+
+// runWatchEvents prints every event the file watcher backend delivers for a directory
+func runWatchEvents(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: go-test-watcher watch-events <directory_to_watch>")
+	}
+	dirToWatch := args[0]
+
+	watcher, err := filenotify.NewForPath(dirToWatch, filenotify.DefaultPollFilesystems)
+	var fallback *filenotify.FallbackError
+	if errors.As(err, &fallback) {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(dirToWatch); err != nil {
+		return fmt.Errorf("failed to add directory to watcher: %w", err)
+	}
+	fmt.Printf("Watching %s with %T. Press Ctrl+C to exit.\n", dirToWatch, watcher)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				return nil
+			}
+			fmt.Printf("Event: %s - %s\n", event.Op, event.Name)
+
+		case err, ok := <-watcher.Errors():
+			if !ok {
+				return nil
+			}
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+}
@@ -5,9 +5,20 @@
 package filenotify
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+
 	"github.com/fsnotify/fsnotify"
 )
 
+// DefaultPollFilesystems lists filesystem types on which fs events are known not to propagate reliably
+var DefaultPollFilesystems = []string{"overlay", "nfs", "9p", "fuse", "cifs", "smb2", "vboxsf"}
+
+// ErrUnreliableFilesystem is the reason given by NewForPath for polling a path on a filesystem
+// where fs events are known not to propagate reliably, such as a network share
+var ErrUnreliableFilesystem = errors.New("fs events don't propagate reliably on this filesystem type")
+
 // FileWatcher is an interface for implementing file notification watchers
 type FileWatcher interface {
 	// Events returns the channel for watching events
@@ -18,15 +29,46 @@ type FileWatcher interface {
 	Add(name string) error
 	// Remove stops watching the named file or directory
 	Remove(name string) error
+	// WatchList returns the files and directories being watched
+	WatchList() []string
 	// Close stops watching and closes the channels
 	Close() error
 }
 
-// New tries to use an fs-event watcher, and falls back to the poller if there is an error
+// FallbackError is returned together with a working polling watcher when
+// the fs-event watcher could not be created, so the reason can be reported
+type FallbackError struct {
+	Err error
+}
+
+func (e *FallbackError) Error() string {
+	message := "fs events are unavailable, polling for changes instead: " + e.Err.Error()
+	if hint := watchLimitHint(e.Err); hint != "" {
+		message += "; " + hint
+	}
+	return message
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// New tries to use an fs-event watcher, and falls back to the poller if there is an error.
+// The poller is then returned with a *FallbackError giving the reason.
 func New() (FileWatcher, error) {
 	watcher, err := NewEventWatcher()
 	if err != nil {
-		return NewPollingWatcher(), nil
+		return NewPollingWatcher(), &FallbackError{Err: err}
 	}
 	return watcher, nil
 }
+
+// NewForPath behaves like New, but uses the poller when path is on one of the given filesystem types.
+// The poller is then returned with a *FallbackError wrapping ErrUnreliableFilesystem, as changes are
+// noticed later than with fs events.
+func NewForPath(path string, pollFilesystems []string) (FileWatcher, error) {
+	if fsType, err := FilesystemType(path); err == nil && slices.Contains(pollFilesystems, fsType) {
+		return NewPollingWatcher(), &FallbackError{Err: fmt.Errorf("%s is on a %s filesystem: %w", path, fsType, ErrUnreliableFilesystem)}
+	}
+	return New()
+}
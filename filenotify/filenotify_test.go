@@ -0,0 +1,33 @@
+package filenotify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewForPathReportsPolledFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	fsType, err := FilesystemType(dir)
+	if err != nil {
+		t.Skipf("filesystem type unavailable: %v", err)
+	}
+
+	watcher, err := NewForPath(dir, []string{fsType})
+	if watcher == nil {
+		t.Fatalf("NewForPath() returned no watcher, err = %v", err)
+	}
+	defer watcher.Close()
+	if _, ok := watcher.(*PollingWatcher); !ok {
+		t.Errorf("NewForPath() = %T, want *PollingWatcher", watcher)
+	}
+
+	// The reason is reported like a failure to create the fs-event watcher
+	var fallback *FallbackError
+	if !errors.As(err, &fallback) || !errors.Is(err, ErrUnreliableFilesystem) {
+		t.Fatalf("NewForPath() error = %v, want a *FallbackError wrapping ErrUnreliableFilesystem", err)
+	}
+	if want := dir + " is on a " + fsType + " filesystem"; !strings.Contains(err.Error(), want) {
+		t.Errorf("NewForPath() error = %q, want it to contain %q", err, want)
+	}
+}
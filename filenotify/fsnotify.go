@@ -1,15 +1,21 @@
 package filenotify
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/fsnotify/fsnotify"
 )
 
 // EventWatcher is an implementation of FileWatcher using fsnotify
 type EventWatcher struct {
-	watcher *fsnotify.Watcher
-	events  chan fsnotify.Event
-	errors  chan error
-	stopped bool
+	watcher   *fsnotify.Watcher
+	events    chan fsnotify.Event
+	errors    chan error
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewEventWatcher returns a new EventWatcher
@@ -23,7 +29,8 @@ func NewEventWatcher() (FileWatcher, error) {
 		watcher: watcher,
 		events:  make(chan fsnotify.Event),
 		errors:  make(chan error),
-		stopped: false,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 
 	go eventWatcher.watch()
@@ -43,7 +50,11 @@ func (w *EventWatcher) Errors() <-chan error {
 
 // Add adds a file or directory to the watch list
 func (w *EventWatcher) Add(name string) error {
-	return w.watcher.Add(name)
+	err := w.watcher.Add(name)
+	if hint := watchLimitHint(err); hint != "" {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	return err
 }
 
 // Remove removes a file or directory from the watch list
@@ -51,37 +62,51 @@ func (w *EventWatcher) Remove(name string) error {
 	return w.watcher.Remove(name)
 }
 
-// Close closes the watcher
-func (w *EventWatcher) Close() error {
-	if w.stopped {
-		return nil
-	}
-	w.stopped = true
-
-	// Close the fsnotify watcher
-	err := w.watcher.Close()
+// WatchList returns the files and directories being watched
+func (w *EventWatcher) WatchList() []string {
+	return w.watcher.WatchList()
+}
 
-	// Close the event and error channels
-	close(w.events)
-	close(w.errors)
+// Close closes the watcher. It is safe to call more than once.
+func (w *EventWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		// Stop forwarding before closing the channels, so watch never sends on a closed channel
+		close(w.stop)
+		<-w.done
 
-	return err
+		w.closeErr = w.watcher.Close()
+		close(w.events)
+		close(w.errors)
+	})
+	return w.closeErr
 }
 
-// watch forwards events from the fsnotify watcher to the event channel
+// watch forwards events from the fsnotify watcher to the event channel until the watcher is closed
 func (w *EventWatcher) watch() {
+	defer close(w.done)
+
 	for {
 		select {
+		case <-w.stop:
+			return
 		case event, ok := <-w.watcher.Events:
 			if !ok {
 				return
 			}
-			w.events <- event
+			select {
+			case w.events <- event:
+			case <-w.stop:
+				return
+			}
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
 				return
 			}
-			w.errors <- err
+			select {
+			case w.errors <- err:
+			case <-w.stop:
+				return
+			}
 		}
 	}
 }
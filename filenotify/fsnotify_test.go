@@ -0,0 +1,71 @@
+package filenotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventWatcherCloseUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := NewEventWatcher()
+	if err != nil {
+		t.Skipf("fs events unavailable: %v", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep events coming while the watcher is closed
+	stop := make(chan struct{})
+	writing := make(chan struct{})
+	go func() {
+		defer close(writing)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.go", i%50)), []byte("package x\n"), 0o644)
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-writing
+	}()
+
+	// Read a few events, then leave the rest unread
+	for range 10 {
+		select {
+		case <-watcher.Events():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no events received")
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- watcher.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked while events were unread")
+	}
+
+	if err := watcher.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	for range watcher.Events() {
+		// Drain events sent before Close
+	}
+	if _, ok := <-watcher.Errors(); ok {
+		t.Error("Errors() not closed")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package filenotify
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// filesystemNames maps statfs magic numbers to filesystem type names
+var filesystemNames = map[uint32]string{
+	0x6969:     "nfs",
+	0x794c7630: "overlay",
+	0x01021997: "9p",
+	0x65735546: "fuse",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x786f4256: "vboxsf",
+	0x01021994: "tmpfs",
+	0xef53:     "ext4",
+	0x9123683e: "btrfs",
+	0x58465342: "xfs",
+}
+
+// FilesystemType returns the type of the filesystem containing path
+func FilesystemType(path string) (string, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return "", err
+	}
+
+	magic := uint32(stat.Type)
+	if name, ok := filesystemNames[magic]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("0x%x", magic), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package filenotify
+
+import "errors"
+
+// FilesystemType returns the type of the filesystem containing path
+func FilesystemType(path string) (string, error) {
+	return "", errors.New("filesystem type detection is not supported on this platform")
+}
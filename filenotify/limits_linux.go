@@ -0,0 +1,19 @@
+//go:build linux
+
+package filenotify
+
+import (
+	"errors"
+	"syscall"
+)
+
+// watchLimitHint explains how to raise the inotify limit that caused err, if any
+func watchLimitHint(err error) string {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return "the inotify watch limit is reached, raise it with: sudo sysctl fs.inotify.max_user_watches=524288"
+	case errors.Is(err, syscall.EMFILE):
+		return "the inotify instance limit is reached, raise it with: sudo sysctl fs.inotify.max_user_instances=512"
+	}
+	return ""
+}
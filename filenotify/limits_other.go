@@ -0,0 +1,8 @@
+//go:build !linux
+
+package filenotify
+
+// watchLimitHint explains how to raise the watch limit that caused err, which is only known on Linux
+func watchLimitHint(err error) string {
+	return ""
+}
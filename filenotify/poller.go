@@ -2,7 +2,11 @@ package filenotify
 
 import (
 	"errors"
+	"io/fs"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,6 +19,10 @@ type PollingWatcher struct {
 	interval time.Duration
 	// files is the list of files being watched
 	files map[string]fileInfo
+	// entries holds the contents of each watched directory, keyed by directory
+	entries map[string]map[string]fileInfo
+	// recursive makes watched directories include the contents of their subdirectories
+	recursive bool
 	// events is the channel where events are reported
 	events chan fsnotify.Event
 	// errors is the channel where errors are reported
@@ -25,6 +33,8 @@ type PollingWatcher struct {
 	mutex sync.Mutex
 	// done is closed when polling has stopped
 	done chan struct{}
+	// closeOnce makes Close safe to call more than once
+	closeOnce sync.Once
 }
 
 type fileInfo struct {
@@ -33,20 +43,36 @@ type fileInfo struct {
 	IsDir   bool
 }
 
+// DefaultPollInterval is the time between polls used by NewPollingWatcher
+const DefaultPollInterval = 200 * time.Millisecond
+
 // NewPollingWatcher returns a new polling watcher with the default interval of 200ms
 func NewPollingWatcher() FileWatcher {
-	return NewPollingWatcherWithInterval(200 * time.Millisecond)
+	return NewPollingWatcherWithInterval(DefaultPollInterval)
 }
 
 // NewPollingWatcherWithInterval returns a new polling watcher with the specified interval
 func NewPollingWatcherWithInterval(interval time.Duration) FileWatcher {
+	return newPollingWatcher(interval, false)
+}
+
+// NewRecursivePollingWatcher returns a new polling watcher with the specified interval
+// that also reports changes in subdirectories of the watched directories
+func NewRecursivePollingWatcher(interval time.Duration) FileWatcher {
+	return newPollingWatcher(interval, true)
+}
+
+// newPollingWatcher creates a polling watcher and starts polling
+func newPollingWatcher(interval time.Duration, recursive bool) *PollingWatcher {
 	watcher := &PollingWatcher{
-		interval: interval,
-		files:    make(map[string]fileInfo),
-		events:   make(chan fsnotify.Event),
-		errors:   make(chan error),
-		stop:     make(chan struct{}),
-		done:     make(chan struct{}),
+		interval:  interval,
+		recursive: recursive,
+		files:     make(map[string]fileInfo),
+		entries:   make(map[string]map[string]fileInfo),
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
 	}
 
 	go watcher.poll()
@@ -74,6 +100,15 @@ func (w *PollingWatcher) Add(name string) error {
 	// Add to the watched files
 	w.files[name] = info
 
+	// Remember the directory contents so new and removed files can be detected
+	if info.IsDir {
+		entries, err := w.readEntries(name)
+		if err != nil {
+			return err
+		}
+		w.entries[name] = entries
+	}
+
 	return nil
 }
 
@@ -87,9 +122,18 @@ func (w *PollingWatcher) Remove(name string) error {
 	}
 
 	delete(w.files, name)
+	delete(w.entries, name)
 	return nil
 }
 
+// WatchList returns the files and directories being watched, sorted by name
+func (w *PollingWatcher) WatchList() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return slices.Sorted(maps.Keys(w.files))
+}
+
 // Events returns the event channel
 func (w *PollingWatcher) Events() <-chan fsnotify.Event {
 	return w.events
@@ -100,15 +144,39 @@ func (w *PollingWatcher) Errors() <-chan error {
 	return w.errors
 }
 
-// Close stops the polling watcher
+// Close stops the polling watcher. It is safe to call more than once.
 func (w *PollingWatcher) Close() error {
-	close(w.stop)
-	<-w.done
-	close(w.events)
-	close(w.errors)
+	w.closeOnce.Do(func() {
+		// A poll blocked on an unread event gives up once stop is closed
+		close(w.stop)
+		<-w.done
+		close(w.events)
+		close(w.errors)
+	})
 	return nil
 }
 
+// send reports an event, giving up when the watcher is stopped.
+// It returns false when the event was not delivered because of that.
+func (w *PollingWatcher) send(event fsnotify.Event) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// sendError reports an error, giving up when the watcher is stopped
+func (w *PollingWatcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
 // poll checks for changes to the watched files at the specified interval
 func (w *PollingWatcher) poll() {
 	defer close(w.done)
@@ -139,15 +207,17 @@ func (w *PollingWatcher) checkFiles() {
 			// Check if the file was deleted
 			if os.IsNotExist(err) {
 				// Fire a delete event
-				w.events <- fsnotify.Event{
-					Name: name,
-					Op:   fsnotify.Remove,
+				if !w.send(fsnotify.Event{Name: name, Op: fsnotify.Remove}) {
+					return
 				}
 				// Remove the file from our tracking
 				delete(w.files, name)
+				delete(w.entries, name)
 			} else {
 				// Some other error
-				w.errors <- err
+				if !w.sendError(err) {
+					return
+				}
 			}
 			continue
 		}
@@ -162,12 +232,108 @@ func (w *PollingWatcher) checkFiles() {
 		// Check if the file was modified
 		if currentInfo.ModTime != oldInfo.ModTime || currentInfo.Size != oldInfo.Size {
 			// Fire a modify event
-			w.events <- fsnotify.Event{
-				Name: name,
-				Op:   fsnotify.Write,
+			if !w.send(fsnotify.Event{Name: name, Op: fsnotify.Write}) {
+				return
 			}
 			// Update the file info
 			w.files[name] = currentInfo
 		}
+
+		if currentInfo.IsDir && !w.checkEntries(name) {
+			return
+		}
+	}
+}
+
+// checkEntries compares the contents of a watched directory with the last poll and
+// reports created, removed and modified files like fsnotify does for directory watches.
+// It returns false when the watcher was stopped before all changes were reported.
+func (w *PollingWatcher) checkEntries(dir string) bool {
+	currentEntries, err := w.readEntries(dir)
+	if err != nil {
+		return w.sendError(err)
+	}
+	oldEntries := w.entries[dir]
+
+	for path, currentInfo := range currentEntries {
+		oldInfo, existed := oldEntries[path]
+		delivered := true
+		switch {
+		case !existed:
+			delivered = w.send(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case !currentInfo.IsDir && (currentInfo.ModTime != oldInfo.ModTime || currentInfo.Size != oldInfo.Size):
+			delivered = w.send(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+		if !delivered {
+			return false
+		}
+	}
+
+	for path := range oldEntries {
+		if _, exists := currentEntries[path]; !exists && !w.send(fsnotify.Event{Name: path, Op: fsnotify.Remove}) {
+			return false
+		}
+	}
+
+	w.entries[dir] = currentEntries
+	return true
+}
+
+// readEntries returns the info of the entries inside dir, keyed by path
+func (w *PollingWatcher) readEntries(dir string) (map[string]fileInfo, error) {
+	if w.recursive {
+		return readTree(dir)
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]fileInfo, len(dirEntries))
+	for _, entry := range dirEntries {
+		info, err := entry.Info()
+		if err != nil {
+			// The entry was removed between listing and stat, it is reported on the next poll
+			continue
+		}
+		entries[filepath.Join(dir, entry.Name())] = fileInfo{
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+		}
+	}
+	return entries, nil
+}
+
+// readTree returns the info of every file and directory below dir, keyed by path
+func readTree(dir string) (map[string]fileInfo, error) {
+	entries := make(map[string]fileInfo)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries removed during the walk are reported on the next poll
+			if os.IsNotExist(err) && path != dir {
+				return nil
+			}
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		entries[path] = fileInfo{
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return entries, nil
 }
@@ -0,0 +1,221 @@
+package filenotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPollingWatcherCloseWithoutDraining(t *testing.T) {
+	dir := t.TempDir()
+	watcher := NewPollingWatcherWithInterval(10 * time.Millisecond)
+	if err := watcher.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create far more files than a reader would take at once, and never read the events
+	for i := range 200 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.go", i)), []byte("package x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- watcher.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on unread events")
+	}
+
+	if err := watcher.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if _, ok := <-watcher.Events(); ok {
+		t.Error("Events channel is still open after Close")
+	}
+}
+
+func TestPollingWatcherWatchList(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+	if err := os.WriteFile(file, []byte("package calc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewPollingWatcherWithInterval(time.Hour)
+	defer watcher.Close()
+	for _, name := range []string{file, dir} {
+		if err := watcher.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := watcher.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := watcher.WatchList(); !slices.Equal(got, []string{dir}) {
+		t.Errorf("WatchList() = %v, want [%s]", got, dir)
+	}
+}
+
+// pollEvents runs one poll of w and returns the events it reports as "OP path", with paths relative
+// to dir, sorted as the poll order is random. Events of dir itself, whose modification time
+// depends on the timestamp granularity of the filesystem, are left out.
+func pollEvents(t *testing.T, w *PollingWatcher, dir string) []string {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.checkFiles()
+	}()
+
+	var events []string
+	for {
+		select {
+		case event := <-w.Events():
+			if event.Name == dir {
+				continue
+			}
+			rel, err := filepath.Rel(dir, event.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event.Op.String()+" "+filepath.ToSlash(rel))
+		case err := <-w.Errors():
+			t.Fatalf("poll error: %v", err)
+		case <-done:
+			slices.Sort(events)
+			return events
+		}
+	}
+}
+
+func TestPollingWatcherEvents(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+	sub := filepath.Join(dir, "internal")
+
+	// Polls are run by the test instead of the ticker
+	w := newPollingWatcher(time.Hour, false)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func() error
+		want   []string
+	}{
+		{
+			name: "create",
+			change: func() error {
+				if err := os.WriteFile(file, []byte("package calc\n"), 0o644); err != nil {
+					return err
+				}
+				return os.Mkdir(sub, 0o755)
+			},
+			want: []string{"CREATE calc.go", "CREATE internal"},
+		},
+		{
+			name: "write",
+			change: func() error {
+				return os.WriteFile(file, []byte("package calc\n\nfunc Add(a, b int) int { return a + b }\n"), 0o644)
+			},
+			want: []string{"WRITE calc.go"},
+		},
+		{
+			// The poller sees a rename as the old name removed and the new one created
+			name: "rename",
+			change: func() error {
+				if err := os.Rename(file, filepath.Join(dir, "add.go")); err != nil {
+					return err
+				}
+				return os.Rename(sub, filepath.Join(dir, "pkg"))
+			},
+			want: []string{"CREATE add.go", "CREATE pkg", "REMOVE calc.go", "REMOVE internal"},
+		},
+		{
+			name: "remove",
+			change: func() error {
+				if err := os.Remove(filepath.Join(dir, "add.go")); err != nil {
+					return err
+				}
+				return os.Remove(filepath.Join(dir, "pkg"))
+			},
+			want: []string{"REMOVE add.go", "REMOVE pkg"},
+		},
+		{
+			name:   "unchanged",
+			change: func() error { return nil },
+		},
+	}
+
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatal(err)
+		}
+		if got := pollEvents(t, w, dir); !slices.Equal(got, step.want) {
+			t.Errorf("%s: events = %q, want %q", step.name, got, step.want)
+		}
+	}
+}
+
+func TestRecursivePollingWatcherEvents(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "internal")
+	file := filepath.Join(sub, "calc.go")
+
+	w := newPollingWatcher(time.Hour, true)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes in subdirectories are reported for the watched directory
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("package calc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CREATE internal", "CREATE internal/calc.go"}
+	if got := pollEvents(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("create: events = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(file, []byte("package calc // changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"WRITE internal/calc.go"}
+	if got := pollEvents(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("write: events = %q, want %q", got, want)
+	}
+
+	if err := os.Rename(sub, filepath.Join(dir, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"CREATE pkg", "CREATE pkg/calc.go", "REMOVE internal", "REMOVE internal/calc.go"}
+	if got := pollEvents(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("rename: events = %q, want %q", got, want)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"REMOVE pkg", "REMOVE pkg/calc.go"}
+	if got := pollEvents(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("remove: events = %q, want %q", got, want)
+	}
+}
@@ -2,10 +2,11 @@ module github.com/bond-kaneko/go-test-watcher
 
 go 1.24.2
 
-require github.com/fsnotify/fsnotify v1.9.0
-
 require (
-	github.com/gosuri/uilive v0.0.4 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.32.0 // indirect
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gosuri/uilive v0.0.4
+	golang.org/x/sys v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/mattn/go-isatty v0.0.20 // indirect
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/bond-kaneko/go-test-watcher/filenotify"
 	"github.com/bond-kaneko/go-test-watcher/watcher"
 )
 
@@ -16,12 +23,87 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "watch-events" {
+		if err := runWatchEvents(os.Args[2:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Configure command line arguments
 	versionFlag := flag.Bool("v", false, "Display version information")
 	coverageFlag := flag.Bool("c", false, "Enable test coverage reporting")
-	dirFlag := flag.String("r", "", "Directory to watch (default: current directory)")
+	totalCoverageFlag := flag.Bool("cover-total", false, "Report the aggregate statement coverage computed from a coverage profile")
+	coverPkgFlag := flag.String("cover-pkg", "", "Comma-separated package patterns to measure coverage of with -coverpkg, counting packages without tests (e.g., \"./...\")")
+	coverMinFlag := flag.Float64("cover-min", 0, "Fail runs whose total coverage of the tested packages is below this percentage")
+	dirFlag := flag.String("r", "", "Comma-separated directories to watch, e.g. the modules of a monorepo (default: current directory)")
 	delayFlag := flag.Duration("d", 500*time.Millisecond, "Debounce delay for running tests after changes")
-	filterFlag := flag.String("f", "*.go", "File filter pattern (e.g., \"*.go\", \"*_test.go\")")
+	buildRetriesFlag := flag.Int("build-retries", watcher.DefaultBuildRetries, "Re-run a run that failed to build this many times before reporting it, for files read while half-saved (0 disables)")
+	buildRetryDelayFlag := flag.Duration("build-retry-delay", watcher.DefaultBuildRetryDelay, "Time to wait before retrying a run that failed to build")
+	perPackageDebounceFlag := flag.Bool("per-package-debounce", false, "Debounce each package directory separately, testing each package as soon as its changes settle")
+	triggerFlag := flag.String("trigger", "write,create", "Comma-separated file operations that run tests: create, write, remove, rename and chmod")
+	newTestOnlyFlag := flag.Bool("new-test-only", false, "Test only the package of a newly created _test.go file, without the packages that depend on it")
+	minIntervalFlag := flag.Duration("min-interval", 0, "Minimum time between the end of a run and the start of the next one triggered by changes (e.g., 5s)")
+	filterFlag := flag.String("f", "*.go", "Comma-separated file filter patterns (e.g., \"*.go\", \"*.go,*.tmpl,*.sql\")")
+	dryRunFlag := flag.Bool("dry-run", false, "Print the go test commands and selected packages instead of running them")
+	dryWatchFlag := flag.Bool("dry-watch", false, "Print file events and whether they match the filter without running tests")
+	defaultPollFS := strings.Join(filenotify.DefaultPollFilesystems, ",")
+	pollFSFlag := flag.String("poll-fs", defaultPollFS, "Comma-separated filesystem types on which polling is used instead of fs events")
+	pollFlag := flag.Bool("poll", false, "Poll for file changes instead of using fs events")
+	pollIntervalFlag := flag.Duration("poll-interval", filenotify.DefaultPollInterval, "Time between polls when -poll is set")
+	ignoreGeneratedFlag := flag.Bool("ignore-generated-headers", false, "Ignore changes to generated Go files that only touch their header comments")
+	fmtFlag := flag.Bool("fmt", false, "Format changed Go files with goimports, or gofmt if it is not installed, before running tests")
+	beforeFlag := flag.String("before", "", "Shell command to run before testing when files matching -before-files change, e.g. a code generator")
+	beforeFilesFlag := flag.String("before-files", "", "Comma-separated file patterns whose changes run the -before command (e.g., \"*.proto,*.sql\")")
+	summaryFileFlag := flag.String("summary-file", "", "Write a one-line summary of the latest run to this file, e.g. for a shell prompt")
+	onSuccessFlag := flag.String("on-success", "", "Shell command to run after every passing run")
+	onFailureFlag := flag.String("on-failure", "", "Shell command to run after every run that doesn't pass")
+	lintFlag := flag.Bool("lint", false, "Run a linter on the tested packages after each test run")
+	lintCmdFlag := flag.String("lint-cmd", "golangci-lint run --out-format=json", "Linter command, followed by the package patterns. Issues are read from golangci-lint's JSON output")
+	focusFlag := flag.String("focus", "", "Only watch and test the package in this directory, relative to the watch directory (e.g., ./internal/calc)")
+	followSymlinksFlag := flag.Bool("follow-symlinks", false, "Also watch directories that symlinks point to, skipping symlink cycles")
+	testDirsOnlyFlag := flag.Bool("test-dirs-only", false, "Only watch the directories that contain _test.go files, for faster startup in very large repositories")
+	embedFlag := flag.Bool("embed", false, "Run tests when files embedded with //go:embed change")
+	timeoutFlag := flag.Duration("timeout", 0, "Fail tests running longer than this with go test -timeout, killing the test command a minute later (0 disables)")
+	killGraceFlag := flag.Duration("kill-grace", 5*time.Second, "Time a test process may take to exit after being interrupted before it is killed")
+	goFlag := flag.String("go", "", "Go command to use, e.g. go1.22.0 or the path of a toolchain (default: $GOTESTWATCHER_GO, or go)")
+	envFlag := envValues{}
+	flag.Var(envFlag, "env", "Set an environment variable for the test process as KEY=VALUE, repeatable (e.g., -env CGO_ENABLED=0)")
+	dockerFlag := flag.String("docker", "", "Run the test command in a container of this Docker image, with the watched root mounted at /app")
+	cmdFlag := flag.String("cmd", "go test", "Test command, followed by the test flags and package patterns")
+	runFlag := flag.String("run", "", "Run only tests matching the regular expression")
+	benchFlag := flag.String("bench", "", "Run the benchmarks matching the regular expression instead of the tests")
+	testFlagsFlag := flag.String("testflags", "", "Space-separated flags passed to go test after the package patterns, e.g. \"-shuffle=on -failfast\"")
+	tagsFlag := flag.String("tags", "", "Comma- or space-separated build tags to test with, e.g. \"integration\"")
+	parallelFlag := flag.Int("p", 0, "Number of packages go test builds and tests in parallel (0 uses the go default)")
+	batchFlag := flag.Int("batch", 0, "Test at most this many packages per go test invocation, running batches one after another (0 disables)")
+	raceFlag := flag.Bool("race", false, "Run tests with the race detector")
+	jsonFlag := flag.Bool("json", false, "Run go test with -json to detect results reliably (ignored with -cmd)")
+	includeVendorFlag := flag.Bool("include-vendor", false, "Watch vendor directories, which are skipped by default, even if .gitignore ignores them")
+	excludeFlag := flag.String("exclude", "", "Comma-separated glob patterns of files and directories to skip (e.g., \"testdata/*,*.pb.go\")")
+	oneFailureFlag := flag.Bool("one-failure", false, "Show only the first failing test in full with the number of failures, pressing n to show the next one")
+	briefFlag := flag.Bool("brief-failures", false, "Show only the failed assertions of failing tests instead of their full output")
+	colorFlag := flag.String("color", "auto", "Color result headlines: auto, always or never (auto respects NO_COLOR)")
+	noTTYFlag := flag.Bool("no-tty", false, "Write output as plain lines instead of updating it in place, as is done when output is not a terminal")
+	clearFlag := flag.Bool("clear", false, "Clear the screen before each test run")
+	bellFlag := flag.String("bell", string(watcher.DefaultBellMode), "When to sound the terminal bell: never, fail (every failing run) or transition (only when passing tests start failing)")
+	notifyFlag := flag.Bool("notify", false, "Show desktop notifications when tests start or stop failing")
+	webhookFlag := flag.String("webhook", "", "URL to POST a JSON payload to when tests start or stop failing, e.g. a Slack incoming webhook")
+	noCacheFlag := flag.Bool("no-cache", false, "Disable the test cache by running tests with -count=1")
+	quietFlag := flag.Bool("quiet", false, "Run tests without -v and only show the summary for passing runs")
+	flakyRetriesFlag := flag.Int("flaky-retries", 0, "Re-run failed tests in isolation up to this many times and report those that pass as flaky (0 disables)")
+	allFlag := flag.Bool("all", false, "Test all packages on every change instead of only the affected packages")
+	fullEveryFlag := flag.Int("full-every", 0, "Test all packages after this many runs of only the affected packages (0 disables)")
+	failedFirstFlag := flag.Bool("failed-first", false, "Run previously failed tests first and the affected packages once they pass")
+	outputFlag := flag.String("output", "text", "Output format: text, or json to write a JSON report line to stdout for every run")
+	keysFlag := flag.Bool("keys", true, "Enable keyboard controls when stdin is a terminal (r: re-run all, f: re-run failed, c: toggle coverage, n: next failure, s: slowest tests, q: quit)")
+	noInitialFlag := flag.Bool("no-initial", false, "Don't run the tests on startup, only after changes")
+	slowestFlag := flag.Int("slowest", 0, "List this many of the slowest tests of the session when the watcher stops (0 disables)")
+	gitChangedFlag := flag.Bool("git-changed", false, "Test only the packages affected by the files changed in git, staged or not, on startup or with -once")
+	onceFlag := flag.Bool("once", false, "Run the tests once and exit with a non-zero status if they fail")
+	logLevelFlag := flag.String("log-level", "info", "Level of diagnostic messages written to stderr: debug, info, warn or error (debug shows events and selected packages)")
+	configFlag := flag.String("config", "", "Config file with default options (default: .gotestwatcher.yml in the watch directory)")
 	flag.Parse()
 
 	// Display version if requested
@@ -30,38 +112,338 @@ func main() {
 		return
 	}
 
-	// Create a new test watcher for the current directory
-	testWatcher, err := watcher.NewTestWatcher(*dirFlag)
+	// Keep stdout for the JSON reports and write everything else to stderr
+	var reports *os.File
+	switch *outputFlag {
+	case "text":
+	case "json":
+		reports = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		fmt.Printf("Error: invalid -output value %q, must be text or json\n", *outputFlag)
+		os.Exit(1)
+	}
+
+	// Options from the config file apply unless they are given on the command line
+	path, required := configPath(*configFlag, *dirFlag)
+	cfg, err := loadConfig(path, required)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg != nil {
+		if err := applyConfig(flag.CommandLine, cfg); err != nil {
+			fmt.Printf("Error in %s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(*logLevelFlag)); err != nil {
+		fmt.Printf("Error: invalid -log-level value %q, must be debug, info, warn or error\n", *logLevelFlag)
+		os.Exit(1)
+	}
+
+	// Create a new test watcher for the watched directories
+	testWatcher, err := watcher.NewTestWatcherWithRoots(splitList(*dirFlag))
 	if err != nil {
 		fmt.Printf("Error creating test watcher: %v\n", err)
 		os.Exit(1)
 	}
 
+	testWatcher.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
+	if *pollFSFlag != defaultPollFS {
+		if err := testWatcher.SetPollFilesystems(splitList(*pollFSFlag)); err != nil {
+			fmt.Printf("Error creating test watcher: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *pollFlag {
+		if *pollIntervalFlag <= 0 {
+			fmt.Println("Error: -poll-interval must be positive")
+			os.Exit(1)
+		}
+		testWatcher.ForcePolling(*pollIntervalFlag)
+	}
+
+	testCommand := strings.Fields(*cmdFlag)
+	if len(testCommand) == 0 {
+		fmt.Println("Error: -cmd must not be empty")
+		os.Exit(1)
+	}
+	testWatcher.SetTestCommand(testCommand[0], testCommand[1:])
+	testWatcher.SetEnv(envFlag)
+	if err := testWatcher.SetDockerImage(*dockerFlag); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The go command replaces "go" in the test command
+	goBinary := *goFlag
+	if goBinary == "" {
+		goBinary = os.Getenv("GOTESTWATCHER_GO")
+	}
+	if goBinary != "" {
+		testWatcher.SetGoBinary(goBinary)
+	}
+
+	testWatcher.SetRunPattern(*runFlag)
+	testWatcher.SetBenchmarks(*benchFlag)
+	// Arguments after "--" are passed to go test like -testflags
+	testWatcher.SetExtraArgs(append(strings.Fields(*testFlagsFlag), flag.Args()...))
+	testWatcher.SetBuildTags(strings.FieldsFunc(*tagsFlag, func(r rune) bool {
+		return r == ',' || r == ' '
+	}))
+	testWatcher.SetFailedFirst(*failedFirstFlag)
+	testWatcher.SetSkipInitialRun(*noInitialFlag)
+	if *parallelFlag < 0 || *batchFlag < 0 {
+		fmt.Println("Error: -p and -batch must not be negative")
+		os.Exit(1)
+	}
+	testWatcher.SetParallelPackages(*parallelFlag)
+	testWatcher.SetBatchSize(*batchFlag)
+	if *flakyRetriesFlag < 0 {
+		fmt.Println("Error: -flaky-retries must not be negative")
+		os.Exit(1)
+	}
+	testWatcher.SetFlakyRetries(*flakyRetriesFlag)
+	if *fullEveryFlag < 0 {
+		fmt.Println("Error: -full-every must not be negative")
+		os.Exit(1)
+	}
+	testWatcher.SetFullRunEvery(*fullEveryFlag)
+	if *allFlag && *focusFlag != "" {
+		fmt.Println("Error: -all cannot be used with -focus")
+		os.Exit(1)
+	}
+	testWatcher.SetAlwaysRunAll(*allFlag)
+	testWatcher.SetVerbose(!*quietFlag)
+	testWatcher.EnableJSON(*jsonFlag)
+	testWatcher.SetDisableCache(*noCacheFlag)
+
 	// Set debounce delay
 	testWatcher.SetDebounceDelay(*delayFlag)
+	testWatcher.SetPerPackageDebounce(*perPackageDebounceFlag)
+	testWatcher.SetBuildRetries(*buildRetriesFlag, *buildRetryDelayFlag)
+	testWatcher.SetMinInterval(*minIntervalFlag)
+	triggerOps, err := watcher.ParseTriggerOps(*triggerFlag)
+	if err != nil {
+		fmt.Printf("Error in -trigger: %v\n", err)
+		os.Exit(1)
+	}
+	testWatcher.SetTriggerOps(triggerOps)
+	testWatcher.SetNewTestFileOnly(*newTestOnlyFlag)
+
+	testWatcher.SetKillGracePeriod(*killGraceFlag)
+	testWatcher.SetTimeout(*timeoutFlag)
 
 	// Set file filter if provided
-	if *filterFlag != "" {
+	if patterns := splitList(*filterFlag); len(patterns) > 0 {
+		// Patterns are checked once here, so matching can't fail for every file event
+		for _, pattern := range patterns {
+			if err := checkPattern(pattern); err != nil {
+				fmt.Printf("Error in -f: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		testWatcher.SetFileFilter(func(path string) bool {
-			matched, err := filepath.Match(*filterFlag, filepath.Base(path))
-			if err != nil {
-				fmt.Printf("Error in file filter pattern: %v\n", err)
-				return false // Or handle error appropriately
+			for _, pattern := range patterns {
+				if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+					return true
+				}
 			}
-			return matched
+			return false
 		})
 	}
 
+	if *raceFlag {
+		testWatcher.EnableRace(true)
+		fmt.Println("Race detector enabled")
+	}
+
+	if *excludeFlag != "" {
+		patterns := splitList(*excludeFlag)
+		for _, pattern := range patterns {
+			if err := checkPattern(pattern); err != nil {
+				fmt.Printf("Error in -exclude: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		testWatcher.SetExcludePatterns(patterns)
+	}
+	testWatcher.SetIncludeVendor(*includeVendorFlag)
+
 	// Set coverage option
 	if *coverageFlag {
 		testWatcher.EnableCoverage(true)
 		fmt.Println("Test coverage reporting enabled")
 	}
 
-	go func() {
-		if err := testWatcher.Watch(); err != nil {
-			fmt.Printf("Error watching: %v\n", err)
+	if *totalCoverageFlag {
+		testWatcher.EnableTotalCoverage(true)
+		fmt.Println("Total coverage reporting enabled")
+	}
+
+	if *coverPkgFlag != "" {
+		testWatcher.SetCoverPackages(splitList(*coverPkgFlag))
+		fmt.Printf("Measuring coverage of %s\n", *coverPkgFlag)
+	}
+
+	if *coverMinFlag < 0 || *coverMinFlag > 100 {
+		fmt.Println("Error: -cover-min must be between 0 and 100")
+		os.Exit(1)
+	}
+	testWatcher.SetCoverageThreshold(*coverMinFlag)
+
+	if *beforeFlag != "" {
+		patterns := splitList(*beforeFilesFlag)
+		if len(patterns) == 0 {
+			fmt.Println("Error: -before requires -before-files")
+			os.Exit(1)
+		}
+		testWatcher.SetPreRunHook(watcher.ShellHook(*beforeFlag))
+		testWatcher.SetPreRunPatterns(patterns)
+	}
+
+	testWatcher.SetSummaryFile(*summaryFileFlag)
+	testWatcher.SetOnSuccess(*onSuccessFlag)
+	testWatcher.SetOnFailure(*onFailureFlag)
+
+	if *lintFlag {
+		lintCommand := strings.Fields(*lintCmdFlag)
+		if len(lintCommand) == 0 {
+			fmt.Println("Error: -lint-cmd must not be empty")
+			os.Exit(1)
+		}
+		testWatcher.SetLintCommand(lintCommand[0], lintCommand[1:])
+		testWatcher.EnableLint(true)
+	}
+
+	bellMode, err := watcher.ParseBellMode(*bellFlag)
+	if err != nil {
+		fmt.Printf("Error in -bell: %v\n", err)
+		os.Exit(1)
+	}
+	testWatcher.SetBellMode(bellMode)
+	testWatcher.SetNotifications(*notifyFlag)
+	testWatcher.SetWebhook(*webhookFlag)
+	testWatcher.SetKeyboardControls(*keysFlag)
+	testWatcher.SetClearScreen(*clearFlag)
+	testWatcher.SetPlainOutput(*noTTYFlag || !isTerminal(os.Stdout))
+
+	color, err := useColor(*colorFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	testWatcher.SetColor(color)
+	testWatcher.SetBriefFailures(*briefFlag)
+	testWatcher.SetSingleFailure(*oneFailureFlag)
+	testWatcher.SetWatchEmbeds(*embedFlag)
+	testWatcher.SetFollowSymlinks(*followSymlinksFlag)
+	testWatcher.SetTestDirsOnly(*testDirsOnlyFlag)
+	if err := testWatcher.SetFocus(*focusFlag); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	testWatcher.SetDryWatch(*dryWatchFlag)
+	testWatcher.SetDryRun(*dryRunFlag)
+	testWatcher.SetIgnoreGeneratedHeaders(*ignoreGeneratedFlag)
+	testWatcher.SetFormatOnSave(*fmtFlag)
+	if reports != nil {
+		testWatcher.SetJSONOutput(reports)
+	}
+
+	// Shut down cleanly on Ctrl+C or termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	testWatcher.SetGitChanged(*gitChangedFlag)
+	testWatcher.SetSlowestTests(*slowestFlag)
+
+	if *onceFlag {
+		result, err := testWatcher.RunOnce(ctx)
+		if err != nil && result.Outcome == "" {
+			fmt.Printf("Error running tests: %v\n", err)
+		}
+		// A dry run has no outcome to report
+		if result.Outcome != watcher.OutcomePassed && !*dryRunFlag {
 			os.Exit(1)
 		}
-	}()
+		return
+	}
+
+	if err := testWatcher.WatchContext(ctx); err != nil {
+		fmt.Printf("Error watching: %v\n", err)
+		os.Exit(1)
+	}
+	// Like -once, the exit status tells whether the tests passed when the watcher was stopped
+	if outcome := testWatcher.LastResult().Outcome; outcome != "" && outcome != watcher.OutcomePassed {
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma-separated flag value, trimming whitespace and dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkPattern reports an error for a malformed glob pattern, which would never match any file
+func checkPattern(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// envValues collects the KEY=VALUE pairs of a repeatable flag
+type envValues map[string]string
+
+func (e envValues) String() string {
+	pairs := make([]string, 0, len(e))
+	for key, value := range e {
+		pairs = append(pairs, key+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (e envValues) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid environment variable %q, must be KEY=VALUE", value)
+	}
+	e[key] = val
+	return nil
+}
+
+// useColor resolves the -color mode, coloring automatically only on a terminal when NO_COLOR is unset
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return isTerminal(os.Stdout), nil
+	default:
+		return false, fmt.Errorf("invalid -color value %q, must be auto, always or never", mode)
+	}
+}
+
+// isTerminal reports whether file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,7 @@
+// Package notify shows desktop notifications using the notification tool of the operating system.
+package notify
+
+// Send shows a desktop notification with the given title and message
+func Send(title, message string) error {
+	return send(title, message)
+}
@@ -0,0 +1,9 @@
+//go:build linux
+
+package notify
+
+import "os/exec"
+
+func send(title, message string) error {
+	return exec.Command("notify-send", "--app-name=go-test-watcher", title, message).Run()
+}
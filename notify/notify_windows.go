@@ -0,0 +1,27 @@
+//go:build windows
+
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const balloonScript = `Add-Type -AssemblyName System.Windows.Forms
+$icon = New-Object System.Windows.Forms.NotifyIcon
+$icon.Icon = [System.Drawing.SystemIcons]::Information
+$icon.Visible = $true
+$icon.ShowBalloonTip(5000, '%s', '%s', [System.Windows.Forms.ToolTipIcon]::None)
+Start-Sleep -Seconds 5
+$icon.Dispose()`
+
+func send(title, message string) error {
+	script := fmt.Sprintf(balloonScript, quote(title), quote(message))
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Start()
+}
+
+// quote escapes s for use in a single-quoted PowerShell string
+func quote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
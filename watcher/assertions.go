@@ -0,0 +1,129 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// assertionPattern matches the "file_test.go:12: message" lines that t.Errorf, t.Fatalf and friends log
+var assertionPattern = regexp.MustCompile(`^(\s*)(\S+\.go:\d+): (.*)$`)
+
+// assertion is a failure logged by a test, with the lines of a multi-line message joined
+type assertion struct {
+	location string
+	message  string
+}
+
+// SetBriefFailures collapses the output of each failing test to its failed assertions,
+// leaving out the full test output shown below them by default
+func (tw *TestWatcher) SetBriefFailures(enabled bool) {
+	tw.briefFailures = enabled
+}
+
+// SetSingleFailure shows only the first failing test of a run in full, with the number of failures.
+// The others can be shown one at a time with the n key.
+func (tw *TestWatcher) SetSingleFailure(enabled bool) {
+	tw.singleFailure = enabled
+}
+
+// writeFailureSections writes the sections of the failing tests, or only the first one with the
+// number of failures in single failure mode. The sections are kept to cycle through them.
+func (tw *TestWatcher) writeFailureSections(sections []string) {
+	tw.failureSections = sections
+	tw.failureIndex = 0
+
+	if !tw.singleFailure || len(sections) == 1 {
+		for _, section := range sections {
+			tw.writeFailureSection(section)
+		}
+		return
+	}
+
+	tw.writeFailureSection(sections[0])
+	fmt.Fprint(tw.writer, tw.failurePosition())
+}
+
+// failurePosition tells which of the failures is shown and how to show the next one
+func (tw *TestWatcher) failurePosition() string {
+	position := fmt.Sprintf("Failure %d of %d.", tw.failureIndex+1, len(tw.failureSections))
+	if tw.restoreTerminal != nil {
+		position += " Press n to show the next one."
+	}
+	return position + "\n"
+}
+
+// showNextFailure shows the failing test after the one shown last, starting over after the last one
+func (tw *TestWatcher) showNextFailure() {
+	tw.runMutex.Lock()
+	defer tw.runMutex.Unlock()
+
+	if len(tw.failureSections) == 0 {
+		fmt.Fprintf(tw.writer, "No failures to show.\n")
+		tw.writer.Flush()
+		return
+	}
+
+	tw.failureIndex = (tw.failureIndex + 1) % len(tw.failureSections)
+	fmt.Fprintf(tw.writer, "%s\n\n", tw.colorize(colorRed, "TEST FAILURES:"))
+	tw.writeFailureSection(tw.failureSections[tw.failureIndex])
+	fmt.Fprint(tw.writer, tw.failurePosition())
+	tw.writer.Flush()
+}
+
+// parseAssertions returns the failures logged in the output section of a test.
+// Lines indented below an assertion, such as the "want" part of a multi-line message, belong to it.
+// t.Log lines can't be told apart from failures in the output, so they are listed too.
+func parseAssertions(section string) []assertion {
+	var assertions []assertion
+	indent := -1
+
+	for _, line := range strings.Split(section, "\n") {
+		if match := assertionPattern.FindStringSubmatch(line); match != nil {
+			indent = len(match[1])
+			assertions = append(assertions, assertion{location: match[2], message: match[3]})
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		if indent >= 0 && trimmed != "" && len(line)-len(trimmed) > indent &&
+			!strings.HasPrefix(trimmed, "---") && !strings.HasPrefix(trimmed, "===") {
+			last := &assertions[len(assertions)-1]
+			last.message += "\n" + trimmed
+			continue
+		}
+		indent = -1
+	}
+
+	return assertions
+}
+
+// writeFailureSection writes the failed assertions of a failing test's section, followed by the
+// full section, or only its --- FAIL lines when failures are brief. Sections without assertions, e.g. panics, are written whole.
+func (tw *TestWatcher) writeFailureSection(section string) {
+	assertions := parseAssertions(section)
+	if len(assertions) == 0 {
+		fmt.Fprintf(tw.writer, "%s\n\n", section)
+		return
+	}
+
+	for _, a := range assertions {
+		lines := strings.Split(a.message, "\n")
+		fmt.Fprintf(tw.writer, "%s %s\n", tw.colorize(colorRed, a.location+":"), lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(tw.writer, "    %s\n", line)
+		}
+	}
+
+	if !tw.briefFailures {
+		fmt.Fprintf(tw.writer, "\n%s\n\n", section)
+		return
+	}
+	// The result lines still name the failed tests
+	for _, line := range strings.Split(section, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--- FAIL:") {
+			fmt.Fprintln(tw.writer, line)
+		}
+	}
+	fmt.Fprintln(tw.writer)
+}
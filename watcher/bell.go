@@ -0,0 +1,49 @@
+package watcher
+
+import "fmt"
+
+// BellMode controls when the terminal bell sounds for a run that did not pass
+type BellMode string
+
+const (
+	// BellNever never sounds the bell
+	BellNever BellMode = "never"
+	// BellFail sounds the bell after every run that did not pass
+	BellFail BellMode = "fail"
+	// BellTransition sounds the bell only when the tests go from passing to failing
+	BellTransition BellMode = "transition"
+)
+
+// DefaultBellMode is the bell mode unless SetBellMode is called
+const DefaultBellMode = BellTransition
+
+// ParseBellMode parses a bell mode name: never, fail or transition
+func ParseBellMode(name string) (BellMode, error) {
+	switch mode := BellMode(name); mode {
+	case BellNever, BellFail, BellTransition:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown bell mode %q, expected never, fail or transition", name)
+}
+
+// SetBellMode sets when the terminal bell sounds for a run that did not pass
+func (tw *TestWatcher) SetBellMode(mode BellMode) {
+	tw.bellMode = mode
+}
+
+// ringBell sounds the terminal bell for a run that did not pass, depending on the bell mode.
+// previous is the outcome of the run before it, empty for the first run.
+func (tw *TestWatcher) ringBell(previous Outcome) {
+	switch tw.bellMode {
+	case BellFail:
+	case BellTransition:
+		// The first run has nothing to transition from
+		if previous != OutcomePassed {
+			return
+		}
+	default:
+		return
+	}
+	fmt.Fprint(tw.writer, "\a")
+	tw.writer.Flush()
+}
@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// benchmarkPattern matches benchmark result lines such as
+// "BenchmarkAdd-8   1000000000   0.2500 ns/op   0 B/op   0 allocs/op"
+var benchmarkPattern = regexp.MustCompile(`(?m)^(Benchmark\S+)\s+(\d+)\s+([\d.]+) ns/op(.*)$`)
+
+// Benchmark is the result of a single benchmark
+type Benchmark struct {
+	// Name includes the GOMAXPROCS suffix, e.g. "BenchmarkAdd-8"
+	Name       string
+	Iterations int
+	NsPerOp    float64
+	// Metrics holds the other reported metrics, such as "0 B/op  0 allocs/op"
+	Metrics string
+}
+
+// parseBenchmarks returns the benchmark results reported in go test output
+func parseBenchmarks(output string) []Benchmark {
+	var benchmarks []Benchmark
+	for _, match := range benchmarkPattern.FindAllStringSubmatch(output, -1) {
+		iterations, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		nsPerOp, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			continue
+		}
+		benchmarks = append(benchmarks, Benchmark{
+			Name:       match[1],
+			Iterations: iterations,
+			NsPerOp:    nsPerOp,
+			Metrics:    strings.Join(strings.Fields(match[4]), " "),
+		})
+	}
+	return benchmarks
+}
+
+// SetBenchmarks runs the benchmarks matching pattern instead of the tests of the affected packages.
+// An empty pattern runs tests again.
+func (tw *TestWatcher) SetBenchmarks(pattern string) {
+	tw.benchPattern = pattern
+}
+
+// writeBenchmarks writes the benchmark results as an aligned table
+func (tw *TestWatcher) writeBenchmarks(benchmarks []Benchmark) {
+	fmt.Fprintf(tw.writer, "BENCHMARKS:\n\n")
+	table := tabwriter.NewWriter(tw.writer, 0, 0, 2, ' ', 0)
+	for _, benchmark := range benchmarks {
+		fmt.Fprintf(table, "%s\t%d\t%.2f ns/op\t%s\n", benchmark.Name, benchmark.Iterations, benchmark.NsPerOp, benchmark.Metrics)
+	}
+	table.Flush()
+	fmt.Fprintln(tw.writer)
+}
@@ -0,0 +1,19 @@
+package watcher
+
+// ANSI escape sequences used to color result headlines
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// colorize wraps text in the given color when colored output is enabled.
+// Only single-line headlines are colored, so the escape sequences never
+// straddle a newline and the live writer's redraw stays intact.
+func (tw *TestWatcher) colorize(color, text string) string {
+	if !tw.color {
+		return text
+	}
+	return color + text + colorReset
+}
@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// untestedPackagePattern matches the go test lines of packages without test files, which
+// are "?   pkg [no test files]", or "\tpkg\t\tcoverage: 0.0% of statements" when testing with coverage
+var untestedPackagePattern = regexp.MustCompile(`(?m)^(?:\?\s+(\S+)\s+\[no test files\]|\t(\S+)\t\tcoverage: )`)
+
+// coverBlock is a single statement block recorded in a coverage profile
+type coverBlock struct {
+	statements int
+	count      int
+}
+
+// readCoverProfile merges the blocks of the coverage profile at path into blocks
+func readCoverProfile(path string, blocks map[string]coverBlock) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open coverage profile: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// Each line has the form "file.go:line.col,line.col statements count"
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("malformed coverage profile line: %q", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed statement count in %q: %w", line, err)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("malformed hit count in %q: %w", line, err)
+		}
+
+		// The same block can be reported by several packages when -coverpkg is used
+		block := blocks[fields[0]]
+		block.statements = statements
+		block.count = max(block.count, count)
+		blocks[fields[0]] = block
+	}
+
+	return scanner.Err()
+}
+
+// coveragePercent returns the percentage of statements covered by blocks
+func coveragePercent(blocks map[string]coverBlock) float64 {
+	total, covered := 0, 0
+	for _, block := range blocks {
+		total += block.statements
+		if block.count > 0 {
+			covered += block.statements
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// readCoverProfiles merges the blocks of the coverage profiles at paths
+func readCoverProfiles(paths ...string) (map[string]coverBlock, error) {
+	blocks := make(map[string]coverBlock)
+	for _, path := range paths {
+		if err := readCoverProfile(path, blocks); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// totalCoverage computes the aggregate statement coverage across the given profiles.
+// The untested packages are left out, as they are reported with no coverage at all.
+func totalCoverage(untested []string, paths ...string) (float64, error) {
+	blocks, err := readCoverProfiles(paths...)
+	if err != nil {
+		return 0, err
+	}
+
+	for block := range blocks {
+		// Blocks are keyed by "importpath/file.go:range"
+		file, _, _ := strings.Cut(block, ":")
+		if slices.Contains(untested, path.Dir(file)) {
+			delete(blocks, block)
+		}
+	}
+	return coveragePercent(blocks), nil
+}
+
+// untestedPackages returns the packages that go test reported as having no test files
+func untestedPackages(output string) []string {
+	var packages []string
+	for _, match := range untestedPackagePattern.FindAllStringSubmatch(output, -1) {
+		packages = append(packages, match[1]+match[2])
+	}
+	return packages
+}
+
+// uncoveredPackages returns the packages in the given profiles of which no statement is covered
+func uncoveredPackages(paths ...string) ([]string, error) {
+	blocks, err := readCoverProfiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	covered := make(map[string]bool)
+	for name, block := range blocks {
+		file, _, _ := strings.Cut(name, ":")
+		pkg := path.Dir(file)
+		covered[pkg] = covered[pkg] || block.count > 0 && block.statements > 0
+	}
+
+	var packages []string
+	for pkg, ok := range covered {
+		if !ok {
+			packages = append(packages, pkg)
+		}
+	}
+	slices.Sort(packages)
+	return packages, nil
+}
@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer runs a function once a burst of triggers has been quiet for the delay.
+// Triggers with different keys are debounced independently, each running the function with its key.
+type debouncer struct {
+	delay  time.Duration
+	fn     func(key string)
+	mutex  sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// newDebouncer returns a debouncer that runs fn delay after the last trigger
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	return newKeyedDebouncer(delay, func(string) { fn() })
+}
+
+// newKeyedDebouncer returns a debouncer that runs fn with a key delay after the last trigger of that key
+func newKeyedDebouncer(delay time.Duration, fn func(key string)) *debouncer {
+	return &debouncer{delay: delay, fn: fn, timers: make(map[string]*time.Timer)}
+}
+
+// Trigger schedules fn, postponing a run that is still pending
+func (d *debouncer) Trigger() {
+	d.TriggerKey("")
+}
+
+// TriggerKey schedules fn for key, postponing a run for key that is still pending
+func (d *debouncer) TriggerKey(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if timer, ok := d.timers[key]; ok {
+		timer.Reset(d.delay)
+		return
+	}
+	d.timers[key] = time.AfterFunc(d.delay, func() { d.fn(key) })
+}
+
+// Stop cancels the pending runs
+func (d *debouncer) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, timer := range d.timers {
+		timer.Stop()
+	}
+}
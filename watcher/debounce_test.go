@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebouncerRunsOnceForBurst(t *testing.T) {
+	var runs atomic.Int32
+	debounce := newDebouncer(50*time.Millisecond, func() {
+		runs.Add(1)
+	})
+
+	// Three rapid saves, each well within the debounce delay of the previous one
+	for range 3 {
+		debounce.Trigger()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("runs = %d, want 1", got)
+	}
+
+	// A later change runs again
+	debounce.Trigger()
+	time.Sleep(200 * time.Millisecond)
+	if got := runs.Load(); got != 2 {
+		t.Errorf("runs after second burst = %d, want 2", got)
+	}
+}
+
+func TestDebouncerStop(t *testing.T) {
+	var runs atomic.Int32
+	debounce := newDebouncer(20*time.Millisecond, func() {
+		runs.Add(1)
+	})
+
+	debounce.Trigger()
+	debounce.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	if got := runs.Load(); got != 0 {
+		t.Errorf("runs = %d, want 0", got)
+	}
+}
+
+func TestKeyedDebouncerRunsEachKey(t *testing.T) {
+	runs := make(chan string, 10)
+	debounce := newKeyedDebouncer(50*time.Millisecond, func(key string) {
+		runs <- key
+	})
+
+	// calc settles while api keeps changing
+	debounce.TriggerKey("calc")
+	for range 4 {
+		debounce.TriggerKey("api")
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := <-runs; got != "calc" {
+		t.Errorf("first run = %q, want calc", got)
+	}
+	if got := <-runs; got != "api" {
+		t.Errorf("second run = %q, want api", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if len(runs) != 0 {
+		t.Errorf("%d extra runs, want none", len(runs))
+	}
+}
+
+func TestChangedFilesAnnouncement(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{nil, "Files changed. Running tests again."},
+		{[]string{"/src/a.go"}, "/src/a.go changed. Running tests again."},
+		{[]string{"/src/b.go", "/src/a.go", "/src/c.go"}, "3 files changed. Running tests again."},
+	}
+
+	for _, tt := range tests {
+		if got := changedFilesAnnouncement(tt.files); got != tt.want {
+			t.Errorf("changedFilesAnnouncement(%v) = %q, want %q", tt.files, got, tt.want)
+		}
+	}
+}
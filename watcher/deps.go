@@ -0,0 +1,217 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// goListPackage is the part of the "go list -json" output used to build the dependency graph
+type goListPackage struct {
+	Dir          string
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// RefreshDependencies rebuilds the graph of packages affected by changes to each package using go list
+func (tw *TestWatcher) RefreshDependencies() error {
+	packages, err := tw.listRootPackages()
+	if err != nil {
+		return err
+	}
+
+	dependents, dirs := buildDependents(tw.watchDir, packages), tw.packageDirsOf(packages)
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+	tw.packageDependencies = dependents
+	tw.packageDirs = dirs
+	return nil
+}
+
+// listRootPackages lists the packages of every root with go list
+func (tw *TestWatcher) listRootPackages() ([]goListPackage, error) {
+	var packages []goListPackage
+	for _, root := range tw.roots {
+		rootPackages, err := listPackages(tw.goBinary, root, tw.buildTags, tw.rootPatterns(root))
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, rootPackages...)
+	}
+	return packages, nil
+}
+
+// packageDirsOf maps the import path of each package to its directory relative to the watch directory
+func (tw *TestWatcher) packageDirsOf(packages []goListPackage) map[string]string {
+	dirs := make(map[string]string)
+	for _, pkg := range packages {
+		if rel, err := filepath.Rel(tw.watchDir, pkg.Dir); err == nil {
+			dirs[pkg.ImportPath] = filepath.ToSlash(rel)
+		}
+	}
+	return dirs
+}
+
+// packageDir returns the directory of the package with importPath relative to the watch directory, if known
+func (tw *TestWatcher) packageDir(importPath string) (string, bool) {
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+	dir, ok := tw.packageDirs[importPath]
+	return dir, ok
+}
+
+// listedPackageDirs maps the import path of each package of every root to its directory relative to
+// the watch directory, listing the packages when they aren't known yet, as without the dependency graph
+func (tw *TestWatcher) listedPackageDirs() (map[string]string, error) {
+	tw.collectMutex.Lock()
+	dirs := maps.Clone(tw.packageDirs)
+	tw.collectMutex.Unlock()
+	if len(dirs) > 0 {
+		return dirs, nil
+	}
+
+	packages, err := tw.listRootPackages()
+	if err != nil {
+		return nil, err
+	}
+	dirs = tw.packageDirsOf(packages)
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+	tw.packageDirs = dirs
+	return maps.Clone(dirs), nil
+}
+
+// findPackageDirs lists the packages again when the directory of any of importPaths is unknown. Without
+// the dependency graph, as in RunOnce, -all and -focus, no directories are known, and packages created
+// since the graph was built are missing from it.
+func (tw *TestWatcher) findPackageDirs(importPaths []string) error {
+	if !slices.ContainsFunc(importPaths, func(importPath string) bool {
+		_, ok := tw.packageDir(importPath)
+		return !ok
+	}) {
+		return nil
+	}
+
+	packages, err := tw.listRootPackages()
+	if err != nil {
+		return err
+	}
+	dirs := tw.packageDirsOf(packages)
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+	tw.packageDirs = dirs
+	return nil
+}
+
+// listPackages lists the packages matching patterns in dir with go list,
+// including the files constrained to the build tags
+func listPackages(goBinary, dir string, tags, patterns []string) ([]goListPackage, error) {
+	args := []string{"list", "-e", "-json"}
+	if len(tags) > 0 {
+		args = append(args, "-tags="+strings.Join(tags, ","))
+	}
+	cmd := exec.Command(goBinary, append(args, patterns...)...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages: %w", err)
+	}
+
+	var packages []goListPackage
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var pkg goListPackage
+		if err := decoder.Decode(&pkg); err != nil {
+			return nil, fmt.Errorf("failed to decode package list: %w", err)
+		}
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// buildDependents maps each package directory, relative to root, to the directories of
+// the packages whose tests depend on it directly or transitively
+func buildDependents(root string, packages []goListPackage) map[string][]string {
+	dirs := make(map[string]string)
+	for _, pkg := range packages {
+		rel, err := filepath.Rel(root, pkg.Dir)
+		if err != nil {
+			continue
+		}
+		dirs[pkg.ImportPath] = filepath.ToSlash(rel)
+	}
+
+	// Reverse the import edges, keeping test-only imports separate as they are not transitive
+	importedBy := make(map[string][]string)
+	testedBy := make(map[string][]string)
+	for _, pkg := range packages {
+		for _, imported := range pkg.Imports {
+			importedBy[imported] = append(importedBy[imported], pkg.ImportPath)
+		}
+		for _, imported := range slices.Concat(pkg.TestImports, pkg.XTestImports) {
+			testedBy[imported] = append(testedBy[imported], pkg.ImportPath)
+		}
+	}
+
+	dependents := make(map[string][]string)
+	for importPath, dir := range dirs {
+		affected := map[string]bool{importPath: true}
+		queue := []string{importPath}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, dependent := range importedBy[current] {
+				if !affected[dependent] {
+					affected[dependent] = true
+					queue = append(queue, dependent)
+				}
+			}
+		}
+		for _, pkg := range slices.Collect(maps.Keys(affected)) {
+			for _, dependent := range testedBy[pkg] {
+				affected[dependent] = true
+			}
+		}
+
+		for pkg := range affected {
+			if dependentDir, ok := dirs[pkg]; ok && pkg != importPath {
+				dependents[dir] = append(dependents[dir], dependentDir)
+			}
+		}
+		slices.Sort(dependents[dir])
+	}
+
+	return dependents
+}
+
+// importsChanged reports whether the imports of a Go file differ from when it was last seen
+func (tw *TestWatcher) importsChanged(path string) bool {
+	if filepath.Ext(path) != ".go" {
+		return false
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+
+	var imports []string
+	for _, spec := range file.Imports {
+		imports = append(imports, spec.Path.Value)
+	}
+	slices.Sort(imports)
+	key := strings.Join(imports, " ")
+
+	previous, seen := tw.fileImports[path]
+	tw.fileImports[path] = key
+	return !seen || previous != key
+}
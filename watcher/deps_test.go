@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildDependents(t *testing.T) {
+	dir := t.TempDir()
+	// go list -json prints one object per package. Imports of the standard library and of other
+	// modules are not listed as packages.
+	goBinary, _ := fakeGo(t, `cat <<EOF
+{
+	"Dir": "`+dir+`",
+	"ImportPath": "example.com/m",
+	"Imports": ["example.com/m/api", "fmt"]
+}
+{
+	"Dir": "`+filepath.Join(dir, "api")+`",
+	"ImportPath": "example.com/m/api",
+	"Imports": ["example.com/m/store", "github.com/google/uuid"],
+	"XTestImports": ["example.com/m/api", "example.com/m/testkit"]
+}
+{
+	"Dir": "`+filepath.Join(dir, "store")+`",
+	"ImportPath": "example.com/m/store",
+	"Imports": ["fmt"],
+	"TestImports": ["example.com/m/util"]
+}
+{
+	"Dir": "`+filepath.Join(dir, "testkit")+`",
+	"ImportPath": "example.com/m/testkit",
+	"Imports": ["example.com/m/util", "testing"]
+}
+{
+	"Dir": "`+filepath.Join(dir, "util")+`",
+	"ImportPath": "example.com/m/util"
+}
+EOF
+`)
+
+	packages, err := listPackages(goBinary, dir, nil, []string{"./..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dependents := buildDependents(dir, packages)
+
+	tests := []struct {
+		name string
+		dir  string
+		want []string
+	}{
+		{name: "transitive dependents", dir: "store", want: []string{".", "api"}},
+		{name: "direct dependent", dir: "api", want: []string{"."}},
+		{name: "nothing depends on it", dir: "."},
+		// The tests of api import testkit, so api is tested, but packages importing api are not
+		{name: "test-only import", dir: "testkit", want: []string{"api"}},
+		{name: "test-only import of a dependency", dir: "util", want: []string{"api", "store", "testkit"}},
+		{name: "standard library", dir: "fmt"},
+		{name: "other module", dir: "github.com/google/uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dependents[tt.dir]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependents of %q = %q, want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+	if len(dependents) != 4 {
+		t.Errorf("buildDependents() = %q, want only the packages of the module with dependents", dependents)
+	}
+}
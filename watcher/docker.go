@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// dockerWorkDir is where the root being tested is mounted in the container
+const dockerWorkDir = "/app"
+
+// containerCount numbers the containers started by this process, to name them uniquely
+var containerCount atomic.Int64
+
+// SetDockerImage runs the test command in a throwaway container of image instead of locally, with the
+// root being tested mounted as the working directory. The package graph is still built with the local
+// go command. An empty image runs tests locally again.
+func (tw *TestWatcher) SetDockerImage(image string) error {
+	if image != "" {
+		if _, err := exec.LookPath("docker"); err != nil {
+			return fmt.Errorf("failed to find docker, which -docker needs to run tests in a container: %w", err)
+		}
+	}
+	tw.dockerImage = image
+	return nil
+}
+
+// newContainerName returns a name for the container of a test process, unique among the running watchers
+func newContainerName() string {
+	return fmt.Sprintf("go-test-watcher-%d-%d", os.Getpid(), containerCount.Add(1))
+}
+
+// processCommand returns the command and arguments that run the test command with args in dir,
+// wrapped in docker run when tests run in a container. The container is named container, if not empty,
+// so it can be stopped, and runs an init process that passes signals on to the test command.
+func (tw *TestWatcher) processCommand(dir, container string, args []string) (string, []string) {
+	if tw.dockerImage == "" {
+		return tw.testCommand, args
+	}
+
+	dockerArgs := []string{"run", "--rm", "--init"}
+	if container != "" {
+		dockerArgs = append(dockerArgs, "--name", container)
+	}
+	dockerArgs = append(dockerArgs, "-v", dir+":"+dockerWorkDir, "-w", dockerWorkDir)
+	if tw.coverProfile != "" {
+		// The profile is written inside the container, so its directory is shared at the same path
+		profileDir := filepath.Dir(tw.coverProfile)
+		dockerArgs = append(dockerArgs, "-v", profileDir+":"+profileDir)
+	}
+	// The container doesn't inherit the environment, so only the variables set with SetEnv are passed
+	for _, variable := range tw.envList() {
+		dockerArgs = append(dockerArgs, "-e", variable)
+	}
+	dockerArgs = append(dockerArgs, tw.dockerImage, tw.testCommand)
+	return "docker", append(dockerArgs, args...)
+}
+
+// signalContainer sends signal to the container named container. Signals to the docker client don't reach
+// the container, which would keep running the tests and writing to the mounted root.
+func signalContainer(container, signal string) error {
+	return exec.Command("docker", "kill", "--signal="+signal, container).Run()
+}
@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SetDryRun makes RunTests print the test commands it would run, with their working
+// directories and the selected packages, instead of running them
+func (tw *TestWatcher) SetDryRun(enabled bool) {
+	tw.dryRun = enabled
+}
+
+// printDryRun writes the changed files, the packages selected for them and the test commands that
+// would test the packages, without running anything. The selection is that of RunTests, which is
+// then counted as done, so following dry runs show when all packages would be tested.
+func (tw *TestWatcher) printDryRun() {
+	fmt.Fprintln(tw.writer, "DRY RUN: tests are not run")
+	if changedFiles := tw.changedFileList(); len(changedFiles) > 0 {
+		files := make([]string, 0, len(changedFiles))
+		for _, file := range changedFiles {
+			if rel, err := filepath.Rel(tw.watchDir, file); err == nil {
+				file = rel
+			}
+			files = append(files, file)
+		}
+		slices.Sort(files)
+		fmt.Fprintf(tw.writer, "Changed files: %s\n", strings.Join(files, ", "))
+	}
+
+	if tw.runsFailedFirst() {
+		fmt.Fprintf(tw.writer, "Failed tests first: %s\n", strings.Join(tw.failedTestList(), ", "))
+		tw.printDryRunCommands("", tw.failedTestRuns())
+	}
+
+	packages, full, reason := tw.selectPackages()
+	if reason != "" {
+		fmt.Fprintln(tw.writer, reason)
+	}
+	packages = slices.Sorted(slices.Values(packages))
+	fmt.Fprintf(tw.writer, "Packages: %s\n", strings.Join(packages, ", "))
+	tw.printDryRunCommands(tw.runPattern, tw.packageRuns(packages))
+	tw.countRun(full)
+	tw.writer.Flush()
+}
+
+// printDryRunCommands writes the test commands of runs with their working directories
+func (tw *TestWatcher) printDryRunCommands(runPattern string, runs []rootRun) {
+	for _, run := range runs {
+		pattern := runPattern
+		if run.runPattern != "" {
+			pattern = run.runPattern
+		}
+		name, args := tw.processCommand(run.dir, "", tw.buildArgs(pattern, run.packages))
+		fmt.Fprintf(tw.writer, "In %s:\n  %s\n", run.dir, commandLine(name, args))
+	}
+}
+
+// commandLine joins a command and its arguments, quoting the arguments a shell would split or expand
+func commandLine(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]|&;<>()#~") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
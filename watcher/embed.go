@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseEmbedPatterns returns the patterns of the //go:embed directives in Go source
+func parseEmbedPatterns(content []byte) []string {
+	var patterns []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		args, ok := strings.CutPrefix(line, "//go:embed ")
+		if !ok {
+			continue
+		}
+
+		for _, pattern := range splitEmbedArgs(args) {
+			patterns = append(patterns, strings.TrimPrefix(pattern, "all:"))
+		}
+	}
+
+	return patterns
+}
+
+// splitEmbedArgs splits the arguments of a //go:embed directive, which may be quoted
+func splitEmbedArgs(args string) []string {
+	var result []string
+
+	for args = strings.TrimSpace(args); args != ""; args = strings.TrimSpace(args) {
+		if args[0] != '"' && args[0] != '`' {
+			field, rest, _ := strings.Cut(args, " ")
+			result = append(result, field)
+			args = rest
+			continue
+		}
+
+		quoted, err := strconv.QuotedPrefix(args)
+		if err != nil {
+			break
+		}
+		if unquoted, err := strconv.Unquote(quoted); err == nil {
+			result = append(result, unquoted)
+		}
+		args = args[len(quoted):]
+	}
+
+	return result
+}
+
+// updateEmbedPatterns records the files embedded by the Go file at path
+func (tw *TestWatcher) updateEmbedPatterns(path string) {
+	if filepath.Ext(path) != ".go" {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		delete(tw.embedPatterns, path)
+		return
+	}
+
+	patterns := parseEmbedPatterns(content)
+	if len(patterns) == 0 {
+		delete(tw.embedPatterns, path)
+		return
+	}
+
+	dir := filepath.Dir(path)
+	for i, pattern := range patterns {
+		patterns[i] = filepath.Join(dir, filepath.FromSlash(pattern))
+	}
+	tw.embedPatterns[path] = patterns
+}
+
+// embeddingPackageDir returns the directory of the package that embeds path.
+// Matching a directory pattern embeds everything below it, so the ancestors of path are checked too.
+func (tw *TestWatcher) embeddingPackageDir(path string) (string, bool) {
+	for goFile, patterns := range tw.embedPatterns {
+		packageDir := filepath.Dir(goFile)
+
+		for candidate := path; insidePath(packageDir, candidate); candidate = filepath.Dir(candidate) {
+			for _, pattern := range patterns {
+				if matched, _ := filepath.Match(pattern, candidate); matched {
+					return packageDir, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
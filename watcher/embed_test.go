@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddingPackageDir(t *testing.T) {
+	dir := t.TempDir()
+	calc := filepath.Join(dir, "calc")
+	for _, sub := range []string{filepath.Join("calc", "templates"), filepath.Join("calc", "static", "css"), "api"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := filepath.Join(calc, "calc.go")
+	writeFile(t, source, "package calc\n\nimport \"embed\"\n\n//go:embed templates/*.tmpl\nvar templates embed.FS\n\n//go:embed \"all:static\"\nvar static embed.FS\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.updateEmbedPatterns(source)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "file matching a pattern in a subdirectory", path: "calc/templates/report.tmpl", want: calc},
+		{name: "file below an embedded directory", path: "calc/static/css/site.css", want: calc},
+		{name: "file in the directory of a pattern that it doesn't match", path: "calc/templates/notes.txt"},
+		{name: "file of another package", path: "api/report.tmpl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tw.embeddingPackageDir(filepath.Join(dir, filepath.FromSlash(tt.path)))
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("embeddingPackageDir() = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+
+	// Once the directives are removed, nothing embeds the files anymore
+	writeFile(t, source, "package calc\n")
+	tw.updateEmbedPatterns(source)
+	if dir, ok := tw.embeddingPackageDir(filepath.Join(calc, "templates", "report.tmpl")); ok {
+		t.Errorf("embeddingPackageDir() = %q after the directive was removed, want none", dir)
+	}
+}
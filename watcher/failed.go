@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// trackFailures remembers the failed tests of result as package/TestName, where package is
+// the package directory relative to the watch directory
+func (tw *TestWatcher) trackFailures(result Result) {
+	if err := tw.findPackageDirs(result.FailedPackages); err != nil {
+		tw.logger.Warn("could not list packages of failed tests", "err", err)
+	}
+
+	for _, test := range result.FailedTests {
+		importPath, name := splitQualifiedTest(test, result.FailedPackages)
+		dir, ok := tw.packageDir(importPath)
+		if !ok {
+			continue
+		}
+		// Subtests are re-run through their top-level test
+		topLevel, _, _ := strings.Cut(name, "/")
+		tw.TrackFailedTest(dir + "/" + topLevel)
+	}
+}
+
+// splitQualifiedTest splits an "importpath/TestName" failed test into its package and test name
+func splitQualifiedTest(test string, packages []string) (string, string) {
+	importPath := ""
+	for _, pkg := range packages {
+		if strings.HasPrefix(test, pkg+"/") && len(pkg) > len(importPath) {
+			importPath = pkg
+		}
+	}
+	if importPath == "" {
+		return "", test
+	}
+	return importPath, strings.TrimPrefix(test, importPath+"/")
+}
+
+// failedTestRuns returns the runs that test only the tracked failed tests. go test applies one -run
+// pattern to all of its packages, so each package is run with a pattern of its own failed tests, and
+// packages whose failed tests have the same names share a run.
+func (tw *TestWatcher) failedTestRuns() []rootRun {
+	names := make(map[string][]string)
+	for _, test := range tw.failedTestList() {
+		index := strings.LastIndex(test, "/")
+		if index < 0 {
+			continue
+		}
+		pkg := packagePattern(test[:index])
+		names[pkg] = append(names[pkg], regexp.QuoteMeta(test[index+1:]))
+	}
+
+	packages := make(map[string][]string)
+	for pkg, pkgNames := range names {
+		slices.Sort(pkgNames)
+		pattern := "^(" + strings.Join(slices.Compact(pkgNames), "|") + ")$"
+		packages[pattern] = append(packages[pattern], pkg)
+	}
+
+	var runs []rootRun
+	for _, pattern := range slices.Sorted(maps.Keys(packages)) {
+		slices.Sort(packages[pattern])
+		for _, run := range tw.packageRuns(packages[pattern]) {
+			run.runPattern = pattern
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
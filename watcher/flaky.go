@@ -0,0 +1,142 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SetFlakyRetries re-runs each failed test in isolation up to n times, reporting tests that pass
+// on a retry as flaky instead of failed (0 disables)
+func (tw *TestWatcher) SetFlakyRetries(n int) {
+	tw.flakyRetries = n
+}
+
+// FlakyCounts returns how often each test, as "importpath/TestName", has been found flaky
+func (tw *TestWatcher) FlakyCounts() map[string]int {
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+
+	counts := make(map[string]int, len(tw.flakyCounts))
+	for test, count := range tw.flakyCounts {
+		counts[test] = count
+	}
+	return counts
+}
+
+// retryFailedTests re-runs the failed top-level tests of result one at a time and returns
+// the tests, as "importpath/TestName", that passed on a retry. The retries stop with
+// context.Canceled when the run of ctx is cancelled.
+func (tw *TestWatcher) retryFailedTests(ctx context.Context, result Result) ([]string, error) {
+	var tests []string
+	for _, test := range result.FailedTests {
+		importPath, name := splitQualifiedTest(test, result.FailedPackages)
+		topLevel, _, _ := strings.Cut(name, "/")
+		tests = append(tests, importPath+"/"+topLevel)
+	}
+	slices.Sort(tests)
+
+	if err := tw.findPackageDirs(result.FailedPackages); err != nil {
+		return nil, err
+	}
+
+	var flaky []string
+	for _, test := range slices.Compact(tests) {
+		importPath, name := splitQualifiedTest(test, result.FailedPackages)
+		dir, ok := tw.packageDir(importPath)
+		if !ok {
+			continue
+		}
+		pattern := packagePattern(dir)
+
+		for attempt := 1; attempt <= tw.flakyRetries; attempt++ {
+			tw.logger.Debug("retrying failed test", "test", test, "attempt", attempt)
+			passed := true
+			for _, run := range tw.rootRuns([]string{pattern}) {
+				var output bytes.Buffer
+				args := tw.buildArgs("^"+regexp.QuoteMeta(name)+"$", run.packages)
+				err := tw.runTestProcess(ctx, run.dir, args, &output, &output)
+				if errors.Is(err, context.Canceled) {
+					return nil, err
+				}
+				if err != nil {
+					passed = false
+				}
+			}
+			if passed {
+				flaky = append(flaky, test)
+				tw.historyMutex.Lock()
+				tw.flakyCounts[test]++
+				tw.historyMutex.Unlock()
+				break
+			}
+		}
+	}
+	return flaky, nil
+}
+
+// removeFlakyTests drops the failures of the flaky tests and their subtests from result,
+// along with the packages left without failures
+func removeFlakyTests(result *Result, flaky []string) {
+	var remaining []string
+	for _, test := range result.FailedTests {
+		importPath, name := splitQualifiedTest(test, result.FailedPackages)
+		topLevel, _, _ := strings.Cut(name, "/")
+		if slices.Contains(flaky, importPath+"/"+topLevel) {
+			result.Failed--
+			continue
+		}
+		remaining = append(remaining, test)
+	}
+
+	var failedPackages []string
+	for _, pkg := range result.FailedPackages {
+		if slices.ContainsFunc(remaining, func(test string) bool {
+			return strings.HasPrefix(test, pkg+"/")
+		}) {
+			failedPackages = append(failedPackages, pkg)
+		}
+	}
+	result.FailedTests = remaining
+	result.FailedPackages = failedPackages
+	result.Flaky = flaky
+}
+
+// flakyReport describes the flaky tests of a run
+func (tw *TestWatcher) flakyReport(flaky []string) string {
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+
+	var report strings.Builder
+	for _, test := range flaky {
+		message := fmt.Sprintf("FLAKY: %s failed, then passed on a retry (found flaky in %d runs)", test, tw.flakyCounts[test])
+		fmt.Fprintf(&report, "%s\n", tw.colorize(colorYellow, message))
+	}
+	return report.String()
+}
+
+// withoutFlakySections drops the output sections of the flaky tests and their subtests from sections
+func withoutFlakySections(sections []string, flaky []string) []string {
+	var names []string
+	for _, test := range flaky {
+		names = append(names, test[strings.LastIndex(test, "/")+1:])
+	}
+
+	var kept []string
+	for _, section := range sections {
+		firstLine, _, _ := strings.Cut(section, "\n")
+		fields := strings.Fields(firstLine)
+		if len(fields) >= 3 && fields[1] == "RUN" {
+			topLevel, _, _ := strings.Cut(fields[2], "/")
+			if slices.Contains(names, topLevel) {
+				continue
+			}
+		}
+		kept = append(kept, section)
+	}
+	return kept
+}
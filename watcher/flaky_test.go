@@ -0,0 +1,44 @@
+package watcher
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemoveFlakyTests(t *testing.T) {
+	result := Result{
+		FailedPackages: []string{"example.com/calc", "example.com/calc/parse"},
+		FailedTests: []string{
+			"example.com/calc/TestAdd/negative",
+			"example.com/calc/TestAdd",
+			"example.com/calc/TestSub",
+			"example.com/calc/parse/TestParse",
+		},
+		Failed: 4,
+	}
+
+	removeFlakyTests(&result, []string{"example.com/calc/TestAdd", "example.com/calc/parse/TestParse"})
+
+	if want := []string{"example.com/calc/TestSub"}; !slices.Equal(result.FailedTests, want) {
+		t.Errorf("FailedTests = %v, want %v", result.FailedTests, want)
+	}
+	if want := []string{"example.com/calc"}; !slices.Equal(result.FailedPackages, want) {
+		t.Errorf("FailedPackages = %v, want %v", result.FailedPackages, want)
+	}
+	if result.Failed != 1 {
+		t.Errorf("Failed = %d, want 1", result.Failed)
+	}
+}
+
+func TestWithoutFlakySections(t *testing.T) {
+	sections := []string{
+		"=== RUN   TestAdd\n    calc_test.go:12: want 3, got 4\n--- FAIL: TestAdd (0.00s)",
+		"=== RUN   TestAdd/negative\n--- FAIL: TestAdd/negative (0.00s)",
+		"=== RUN   TestSub\n--- FAIL: TestSub (0.00s)",
+	}
+
+	got := withoutFlakySections(sections, []string{"example.com/calc/TestAdd"})
+	if want := sections[2:]; !slices.Equal(got, want) {
+		t.Errorf("withoutFlakySections() = %q, want %q", got, want)
+	}
+}
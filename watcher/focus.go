@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SetFocus restricts watching and testing to the package in dir, relative to the watch directory.
+// Only that directory is watched, changes elsewhere are ignored, and every run tests that package
+// alone, without dependent packages. An empty dir removes the focus.
+func (tw *TestWatcher) SetFocus(dir string) error {
+	if dir == "" {
+		tw.focusDir = ""
+		return nil
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(tw.watchDir, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find focused package: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("focused package %s is not a directory", dir)
+	}
+	if !containsPath(tw.watchDir, dir) {
+		return fmt.Errorf("focused package %s is outside the watch directory %s", dir, tw.watchDir)
+	}
+
+	tw.focusDir = dir
+	return nil
+}
+
+// addFocusWatch watches the focused package directory, without its subdirectories
+func (tw *TestWatcher) addFocusWatch() error {
+	if err := tw.watcher.Add(tw.focusDir); err != nil {
+		return err
+	}
+	tw.logger.Debug("watching directory", "dir", tw.focusDir)
+	tw.watchedDirs[tw.focusDir] = true
+	return nil
+}
+
+// focusPattern returns the package pattern of the focused package
+func (tw *TestWatcher) focusPattern() string {
+	rel, err := filepath.Rel(tw.watchDir, tw.focusDir)
+	if err != nil {
+		return tw.focusDir
+	}
+	return packagePattern(rel)
+}
+
+// outsideFocus reports whether path is not a file of the focused package
+func (tw *TestWatcher) outsideFocus(path string) bool {
+	return tw.focusDir != "" && !samePath(filepath.Dir(path), tw.focusDir)
+}
@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SetFormatOnSave enables formatting changed Go files with goimports, or gofmt when goimports
+// is not installed, before running tests
+func (tw *TestWatcher) SetFormatOnSave(enabled bool) {
+	tw.formatOnSave = enabled
+}
+
+// formatChangedFiles formats the changed Go files in place and returns the reported failures,
+// which don't stop the run
+func (tw *TestWatcher) formatChangedFiles() string {
+	formatter := "goimports"
+	if _, err := exec.LookPath(formatter); err != nil {
+		formatter = "gofmt"
+	}
+
+	var files []string
+	for _, file := range tw.changedFileList() {
+		if filepath.Ext(file) == ".go" {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+
+	var failures strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			// The file was removed since it changed
+			continue
+		}
+		before, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command(formatter, file)
+		cmd.Stderr = &stderr
+		after, err := cmd.Output()
+		if err != nil {
+			fmt.Fprintf(&failures, "FORMAT FAILED: %s\n%s\n", filepath.Base(file), strings.TrimSpace(stderr.String()))
+			continue
+		}
+		if bytes.Equal(before, after) {
+			continue
+		}
+
+		// The rewrite is reported as a change, which must not trigger another run,
+		// so it is remembered before the file is written
+		tw.formatMutex.Lock()
+		tw.formattedHashes[file] = contentHash(after)
+		tw.formatMutex.Unlock()
+		if err := replaceFile(file, after, info.Mode().Perm()); err != nil {
+			fmt.Fprintf(&failures, "FORMAT FAILED: %s\n%v\n", filepath.Base(file), err)
+		}
+	}
+	return failures.String()
+}
+
+// replaceFile atomically replaces the content of path, so watchers never see it partially written
+func replaceFile(path string, content []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := os.Chmod(temp.Name(), perm); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
+
+// isFormatterRewrite reports whether a change to path is the formatter rewriting it, rather than an edit
+func (tw *TestWatcher) isFormatterRewrite(path string) bool {
+	tw.formatMutex.Lock()
+	hash, ok := tw.formattedHashes[path]
+	tw.formatMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || contentHash(content) != hash {
+		// Edited again after formatting
+		tw.formatMutex.Lock()
+		delete(tw.formattedHashes, path)
+		tw.formatMutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// contentHash returns the hex encoded SHA-256 of content
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
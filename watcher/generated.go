@@ -0,0 +1,49 @@
+package watcher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+)
+
+var generatedCodeMarker = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// generatedContentHash hashes a generated Go file without the header comments that precede its package clause.
+// It reports false when the content is not generated code.
+func generatedContentHash(content []byte) (string, bool) {
+	body := content
+	if index := bytes.Index(content, []byte("\npackage ")); index >= 0 {
+		body = content[index+1:]
+	} else if !bytes.HasPrefix(content, []byte("package ")) {
+		return "", false
+	}
+
+	header := content[:len(content)-len(body)]
+	if !generatedCodeMarker.Match(header) {
+		return "", false
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// generatedContentChanged reports whether a file changed outside of its generated code header.
+// Files that are not generated code are always considered changed.
+func (tw *TestWatcher) generatedContentChanged(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return true
+	}
+
+	hash, generated := generatedContentHash(content)
+	if !generated {
+		delete(tw.generatedHashes, path)
+		return true
+	}
+
+	previous, seen := tw.generatedHashes[path]
+	tw.generatedHashes[path] = hash
+	return !seen || previous != hash
+}
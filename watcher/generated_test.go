@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratedContentHash(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		generated bool
+	}{
+		{
+			name:      "standard header",
+			content:   "// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: calc.proto\n\npackage calc\n",
+			generated: true,
+		},
+		{
+			name:      "header after a build constraint",
+			content:   "//go:build linux\n\n// Code generated by stringer -type=Op; DO NOT EDIT.\n\npackage calc\n",
+			generated: true,
+		},
+		{
+			name:    "handwritten",
+			content: "// Package calc adds numbers.\npackage calc\n",
+		},
+		{
+			name:    "marker without DO NOT EDIT",
+			content: "// Code generated by hand.\n\npackage calc\n",
+		},
+		{
+			name:    "marker after the package clause",
+			content: "package calc\n\n// Code generated by mockgen. DO NOT EDIT.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, generated := generatedContentHash([]byte(tt.content)); generated != tt.generated {
+				t.Errorf("generatedContentHash() generated = %v, want %v", generated, tt.generated)
+			}
+		})
+	}
+}
+
+func TestGeneratedHeaderChangesDontTrigger(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.pb.go")
+	handwritten := filepath.Join(dir, "calc.go")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetIgnoreGeneratedHeaders(true)
+
+	// Each change is judged against the content of the file at the previous change
+	steps := []struct {
+		name    string
+		path    string
+		content string
+		want    bool
+	}{
+		{
+			name:    "first change",
+			path:    file,
+			content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n// versions: protoc v4.25.1\n\npackage calc\n\nconst Version = 1\n",
+			want:    true,
+		},
+		{
+			name:    "header-only change",
+			path:    file,
+			content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n// versions: protoc v4.25.3\n\npackage calc\n\nconst Version = 1\n",
+			want:    false,
+		},
+		{
+			name:    "body change",
+			path:    file,
+			content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n// versions: protoc v4.25.3\n\npackage calc\n\nconst Version = 2\n",
+			want:    true,
+		},
+		{
+			name:    "rewritten unchanged",
+			path:    file,
+			content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n// versions: protoc v4.25.3\n\npackage calc\n\nconst Version = 2\n",
+			want:    false,
+		},
+		{
+			name:    "handwritten file",
+			path:    handwritten,
+			content: "package calc\n",
+			want:    true,
+		},
+		{
+			name:    "handwritten file rewritten unchanged",
+			path:    handwritten,
+			content: "package calc\n",
+			want:    true,
+		},
+	}
+
+	for _, step := range steps {
+		writeFile(t, step.path, step.content)
+		if got := tw.shouldTrigger(step.path); got != step.want {
+			t.Errorf("%s: shouldTrigger() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SetGitChanged makes the first run test only the packages affected by the files git reports as changed
+// in the watch directory, staged or not, and by untracked files, instead of every package
+func (tw *TestWatcher) SetGitChanged(enabled bool) {
+	tw.gitChanged = enabled
+}
+
+// addGitChangedFiles adds the files git reports as changed to the changed files, if they would run tests
+// when saved. It reports whether any file was added.
+func (tw *TestWatcher) addGitChangedFiles() (bool, error) {
+	files, err := tw.gitChangedFiles()
+	if err != nil {
+		return false, err
+	}
+
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+
+	added := false
+	for _, file := range files {
+		if tw.shouldTrigger(file) {
+			tw.AddChangedFile(file)
+			added = true
+		}
+	}
+	tw.logger.Debug("git changed files", "files", files, "added", added)
+	return added, nil
+}
+
+// gitChangedFiles returns the absolute paths of the files in the watch directory that differ from HEAD,
+// staged or not, and of the untracked files that are not ignored
+func (tw *TestWatcher) gitChangedFiles() ([]string, error) {
+	if _, err := tw.git("rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("failed to find changed files, %s is not in a git repository: %w", tw.watchDir, err)
+	}
+
+	changed, err := tw.git("diff", "-z", "--name-only", "--relative", "HEAD")
+	if err != nil {
+		// A repository without commits has no HEAD, so everything staged is changed
+		changed, err = tw.git("diff", "-z", "--name-only", "--relative", "--cached")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find changed files: %w", err)
+		}
+	}
+	untracked, err := tw.git("ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find untracked files: %w", err)
+	}
+
+	var files []string
+	for _, name := range strings.Split(changed+untracked, "\x00") {
+		if name != "" {
+			files = append(files, filepath.Join(tw.watchDir, filepath.FromSlash(name)))
+		}
+	}
+	return files, nil
+}
+
+// git runs git with args in the watch directory and returns its output
+func (tw *TestWatcher) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tw.watchDir
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(output), err
+}
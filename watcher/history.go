@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// historySize is the number of recent runs kept by RunHistory
+const historySize = 10
+
+// RunRecord describes a completed test run
+type RunRecord struct {
+	Time time.Time
+	// ChangedFiles lists the absolute paths of the files whose changes triggered the run
+	ChangedFiles []string
+	Outcome      Outcome
+	// Duration is the time spent testing as reported by go test
+	Duration time.Duration
+}
+
+// RunHistory returns the most recent completed runs, oldest first
+func (tw *TestWatcher) RunHistory() []RunRecord {
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+	return slices.Clone(tw.history)
+}
+
+// recordRun adds a completed run to the history, dropping the oldest run once it is full
+func (tw *TestWatcher) recordRun(result Result) {
+	record := RunRecord{
+		Time:     time.Now(),
+		Outcome:  result.Outcome,
+		Duration: result.Duration,
+	}
+	record.ChangedFiles = tw.changedFileList()
+
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+	if len(tw.history) == historySize {
+		tw.history = slices.Delete(tw.history, 0, 1)
+	}
+	tw.history = append(tw.history, record)
+	tw.recordSessionRun(result)
+}
+
+// historyStrip renders the outcomes of the recent runs, oldest first, as one colored symbol each:
+// "." passed, "F" failed, "T" timed out and "B" did not build
+func (tw *TestWatcher) historyStrip() string {
+	history := tw.RunHistory()
+	if len(history) == 0 {
+		return ""
+	}
+
+	var strip strings.Builder
+	strip.WriteString("Recent runs: ")
+	for _, record := range history {
+		switch record.Outcome {
+		case OutcomePassed:
+			strip.WriteString(tw.colorize(colorGreen, "."))
+		case OutcomeFailed:
+			strip.WriteString(tw.colorize(colorRed, "F"))
+		case OutcomeTimedOut:
+			strip.WriteString(tw.colorize(colorRed, "T"))
+		case OutcomeBuildFailed:
+			strip.WriteString(tw.colorize(colorYellow, "B"))
+		}
+	}
+	strip.WriteString("\n")
+	return strip.String()
+}
@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRunHistoryKeepsRecentRuns(t *testing.T) {
+	tw := &TestWatcher{changedFiles: map[string]bool{"/src/calc.go": true}}
+
+	for i := range historySize + 2 {
+		outcome := OutcomePassed
+		if i%2 == 1 {
+			outcome = OutcomeFailed
+		}
+		tw.recordRun(Result{Outcome: outcome})
+	}
+
+	history := tw.RunHistory()
+	if len(history) != historySize {
+		t.Fatalf("len(RunHistory()) = %d, want %d", len(history), historySize)
+	}
+	// The two oldest runs, a pass and a failure, were dropped
+	if history[0].Outcome != OutcomePassed || history[historySize-1].Outcome != OutcomeFailed {
+		t.Errorf("unexpected outcomes: first %s, last %s", history[0].Outcome, history[historySize-1].Outcome)
+	}
+	if len(history[0].ChangedFiles) != 1 || history[0].ChangedFiles[0] != "/src/calc.go" {
+		t.Errorf("ChangedFiles = %v, want [/src/calc.go]", history[0].ChangedFiles)
+	}
+
+	if got, want := tw.historyStrip(), "Recent runs: .F.F.F.F.F\n"; got != want {
+		t.Errorf("historyStrip() = %q, want %q", got, want)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	tw := &TestWatcher{}
+	tw.recordRun(Result{Outcome: OutcomeFailed, FailedTests: []string{"example.com/calc/TestAdd", "example.com/calc/TestSub"}})
+	tw.recordRun(Result{Outcome: OutcomeFailed, FailedTests: []string{"example.com/calc/TestSub"}})
+	tw.recordRun(Result{Outcome: OutcomeBuildFailed})
+	tw.recordRun(Result{Outcome: OutcomePassed})
+	tw.recordTestTime(1500 * time.Millisecond)
+
+	stats := tw.SessionStats()
+	if stats.Runs != 4 || stats.Passed != 1 || stats.Failed != 3 {
+		t.Errorf("runs = %d, passed = %d, failed = %d, want 4, 1, 3", stats.Runs, stats.Passed, stats.Failed)
+	}
+	test, failures, ok := stats.MostFailedTest()
+	if !ok || test != "example.com/calc/TestSub" || failures != 2 {
+		t.Errorf("MostFailedTest() = %q, %d, %v, want example.com/calc/TestSub, 2, true", test, failures, ok)
+	}
+
+	want := "Session summary: 4 runs in 0s, 1 passed, 3 failed, 1.5s spent testing\n" +
+		"Most failing test: example.com/calc/TestSub (failed in 2 runs)\n"
+	if got := tw.sessionSummary(); got != want {
+		t.Errorf("sessionSummary() = %q, want %q", got, want)
+	}
+}
+
+func TestSlowestTests(t *testing.T) {
+	output := `=== RUN   TestAdd
+--- PASS: TestAdd (0.30s)
+=== RUN   TestTable
+=== RUN   TestTable/big
+    --- PASS: TestTable/big (0.90s)
+--- PASS: TestTable (1.00s)
+PASS
+ok  	example.com/calc	1.302s
+`
+	tw := &TestWatcher{}
+	tw.recordRun(parseTextResult(output))
+	tw.recordRun(Result{TestDurations: map[string]time.Duration{
+		"example.com/calc/TestAdd": 100 * time.Millisecond,
+		"example.com/api/TestGet":  300 * time.Millisecond,
+	}})
+
+	want := []TestDuration{
+		{Test: "example.com/calc/TestTable", Duration: time.Second},
+		{Test: "example.com/api/TestGet", Duration: 300 * time.Millisecond},
+		{Test: "example.com/calc/TestAdd", Duration: 300 * time.Millisecond},
+	}
+	if got := tw.SessionStats().SlowestTests(5); !slices.Equal(got, want) {
+		t.Errorf("SlowestTests(5) = %v, want %v", got, want)
+	}
+	if got := tw.SessionStats().SlowestTests(1); !slices.Equal(got, want[:1]) {
+		t.Errorf("SlowestTests(1) = %v, want %v", got, want[:1])
+	}
+}
@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// shellCommand returns a command running command with the system shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// ShellHook returns a hook that runs command with the system shell.
+// The hook's error includes the command's output.
+func ShellHook(command string) func() error {
+	return func() error {
+		output, err := shellCommand(command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w\n%s", command, err, output)
+		}
+		return nil
+	}
+}
+
+// SetPreRunHook sets a hook that runs before tests run for changed files, such as a code generation step.
+// If the hook fails, the error is reported and the tests don't run.
+func (tw *TestWatcher) SetPreRunHook(hook func() error) {
+	tw.preRunHook = hook
+}
+
+// SetPreRunPatterns restricts the pre-run hook to changes of files whose names match one of the glob
+// patterns, such as "*.proto". Matching files trigger the hook even if they don't pass the file filter,
+// and are not tested themselves: the tests run once the changes made by the hook are detected.
+func (tw *TestWatcher) SetPreRunPatterns(patterns []string) {
+	tw.preRunPatterns = patterns
+}
+
+// matchesPreRunPatterns reports whether a change to path triggers the pre-run hook
+func (tw *TestWatcher) matchesPreRunPatterns(path string) bool {
+	if tw.preRunHook == nil {
+		return false
+	}
+	for _, pattern := range tw.preRunPatterns {
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// runPreRunHook runs the pre-run hook if the changed files call for it and reports whether tests should run
+func (tw *TestWatcher) runPreRunHook() bool {
+	changedFiles := tw.changedFileList()
+	if tw.preRunHook == nil || len(changedFiles) == 0 {
+		return true
+	}
+
+	due := len(tw.preRunPatterns) == 0
+	for _, file := range changedFiles {
+		due = due || tw.matchesPreRunPatterns(file)
+	}
+	if !due {
+		return true
+	}
+
+	fmt.Fprintf(tw.writer, "Running before hook...\n")
+	tw.writer.Flush()
+	if err := tw.preRunHook(); err != nil {
+		fmt.Fprintf(tw.writer, "%s\n%v\n", tw.colorize(colorYellow, "BEFORE HOOK FAILED:"), err)
+		tw.writer.Flush()
+		tw.ringBell(tw.previousOutcome)
+		tw.ClearChangedFiles()
+		return false
+	}
+
+	// Files that only trigger the hook have no tests of their own
+	for _, file := range changedFiles {
+		if tw.matchesPreRunPatterns(file) && !tw.fileFilter(file) {
+			tw.removeChangedFile(file)
+		}
+	}
+	if len(tw.changedFileList()) == 0 {
+		fmt.Fprintf(tw.writer, "Before hook done. Tests run once its changes are detected.\n")
+		tw.writer.Flush()
+		return false
+	}
+	return true
+}
+
+// SetOnSuccess sets a shell command to run after every passing run
+func (tw *TestWatcher) SetOnSuccess(command string) {
+	tw.onSuccess = command
+}
+
+// SetOnFailure sets a shell command to run after every run that doesn't pass
+func (tw *TestWatcher) SetOnFailure(command string) {
+	tw.onFailure = command
+}
+
+// runOutcomeHook starts the success or failure command for the outcome of result.
+// The command gets the result in GOTESTWATCHER_* environment variables, and runs in
+// the background so a slow command doesn't hold up the next run, and is awaited by RunOnce and Close.
+// Failures are logged.
+func (tw *TestWatcher) runOutcomeHook(result Result) {
+	command := tw.onFailure
+	if result.Outcome == OutcomePassed {
+		command = tw.onSuccess
+	}
+	if command == "" {
+		return
+	}
+
+	cmd := shellCommand(command)
+	cmd.Dir = tw.watchDir
+	cmd.Env = append(os.Environ(),
+		"GOTESTWATCHER_OUTCOME="+string(result.Outcome),
+		"GOTESTWATCHER_PASSED="+strconv.Itoa(result.Passed),
+		"GOTESTWATCHER_FAILED="+strconv.Itoa(result.Failed),
+		"GOTESTWATCHER_SKIPPED="+strconv.Itoa(result.Skipped),
+		"GOTESTWATCHER_DURATION="+strconv.FormatFloat(result.Duration.Seconds(), 'f', 3, 64),
+	)
+	tw.hooks.Add(1)
+	go func() {
+		defer tw.hooks.Done()
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			tw.logger.Warn("outcome hook failed", "command", command, "err", err, "output", strings.TrimSpace(string(output)))
+		}
+	}()
+}
@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPreRunHook(t *testing.T) {
+	newWatcher := func(hook func() error, changed ...string) *TestWatcher {
+		tw := &TestWatcher{
+			fileFilter:   func(path string) bool { return filepath.Ext(path) == ".go" },
+			changedFiles: make(map[string]bool),
+			writer:       newPlainWriter(io.Discard),
+		}
+		tw.SetPreRunHook(hook)
+		tw.SetPreRunPatterns([]string{"*.proto"})
+		for _, file := range changed {
+			tw.AddChangedFile(file)
+		}
+		return tw
+	}
+
+	calls := 0
+	hook := func() error {
+		calls++
+		return nil
+	}
+
+	tw := newWatcher(hook, "/src/calc.go")
+	if !tw.runPreRunHook() || calls != 0 {
+		t.Errorf("Go change: run = false or hook called %d times", calls)
+	}
+
+	tw = newWatcher(hook, "/src/api.proto")
+	if tw.runPreRunHook() || calls != 1 {
+		t.Errorf("proto change: run = true or hook called %d times", calls)
+	}
+
+	tw = newWatcher(hook, "/src/api.proto", "/src/calc.go")
+	if !tw.runPreRunHook() || calls != 2 {
+		t.Errorf("proto and Go change: run = false or hook called %d times", calls)
+	}
+	if len(tw.changedFiles) != 1 || !tw.changedFiles["/src/calc.go"] {
+		t.Errorf("changed files = %v, want only /src/calc.go", tw.changedFiles)
+	}
+
+	tw = newWatcher(func() error { return errors.New("generation failed") }, "/src/api.proto", "/src/calc.go")
+	if tw.runPreRunHook() {
+		t.Error("failed hook: run = true")
+	}
+	if len(tw.changedFiles) != 0 {
+		t.Errorf("failed hook: changed files = %v, want none", tw.changedFiles)
+	}
+}
+
+func TestWriteSummaryFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status")
+	tw := &TestWatcher{}
+	tw.SetSummaryFile(path)
+
+	for _, result := range []Result{
+		{Outcome: OutcomeFailed, Passed: 12, Failed: 1},
+		{Outcome: OutcomePassed, Passed: 13, Skipped: 2},
+	} {
+		if err := tw.writeSummaryFile(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "PASS passed=13 failed=0 skipped=2 time="; !strings.HasPrefix(got, want) {
+		t.Errorf("summary = %q, want it to start with %q", got, want)
+	}
+	// The temporary files were renamed over the summary
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the summary directory, want 1", len(entries))
+	}
+}
+
+func TestBellMode(t *testing.T) {
+	outcomes := []Outcome{OutcomeFailed, OutcomePassed, OutcomeFailed, OutcomeBuildFailed, OutcomePassed}
+	for _, test := range []struct {
+		mode  BellMode
+		rings int
+	}{
+		{BellNever, 0},
+		{BellFail, 3},
+		// The first failure has nothing to transition from, and the build failure follows a failure
+		{BellTransition, 1},
+	} {
+		t.Run(string(test.mode), func(t *testing.T) {
+			var output bytes.Buffer
+			tw, err := NewTestWatcher(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tw.Close()
+			tw.writer = newPlainWriter(&output)
+			tw.SetBellMode(test.mode)
+
+			for _, outcome := range outcomes {
+				tw.handleOutcome(Result{Outcome: outcome})
+			}
+			if got := strings.Count(output.String(), "\a"); got != test.rings {
+				t.Errorf("bell rang %d times, want %d", got, test.rings)
+			}
+		})
+	}
+}
+
+func TestParseBellMode(t *testing.T) {
+	if mode, err := ParseBellMode("fail"); err != nil || mode != BellFail {
+		t.Errorf("ParseBellMode(\"fail\") = %q, %v, want %q", mode, err, BellFail)
+	}
+	if _, err := ParseBellMode("always"); err == nil {
+		t.Error("ParseBellMode(\"always\") succeeded, want an error")
+	}
+}
+
+func TestCloseWaitsForWebhook(t *testing.T) {
+	var received atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A slow endpoint is still waited for
+		time.Sleep(100 * time.Millisecond)
+		received.Store(true)
+	}))
+	defer server.Close()
+
+	tw, err := NewTestWatcher(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetWebhook(server.URL)
+
+	tw.postWebhook(Result{Outcome: OutcomeFailed, Failed: 1})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !received.Load() {
+		t.Error("Close returned before the webhook request finished")
+	}
+}
@@ -0,0 +1,203 @@
+package watcher
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ignoreRule is a single pattern in .gitignore syntax
+type ignoreRule struct {
+	// base is the directory the pattern is relative to
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseIgnoreRule parses a line of a .gitignore file located in base
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if negated, ok := strings.CutPrefix(line, "!"); ok {
+		rule.negate = true
+		line = negated
+	}
+	line = strings.TrimPrefix(line, `\`)
+
+	if trimmed, ok := strings.CutSuffix(line, "/"); ok {
+		rule.dirOnly = true
+		line = trimmed
+	}
+	// A slash anywhere but at the end anchors the pattern to the base directory
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// matches reports whether the rule matches path
+func (r ignoreRule) matches(name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	rel, err := filepath.Rel(r.base, name)
+	if err != nil || rel == "." || outsideRel(rel) {
+		return false
+	}
+	relSegments := strings.Split(filepath.ToSlash(rel), "/")
+
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], relSegments[len(relSegments)-1])
+		return matched
+	}
+	return matchSegments(r.segments, relSegments)
+}
+
+// matchSegments matches slash-separated path segments against pattern segments, where "**" matches any number of segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// loadGitignore loads the rules of the .gitignore file in dir, replacing those loaded before,
+// so a changed, removed or recreated file is reflected
+func (tw *TestWatcher) loadGitignore(dir string) {
+	delete(tw.gitignoreRules, dir)
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(dir, scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) > 0 {
+		tw.gitignoreRules[dir] = rules
+	}
+}
+
+// SetIncludeVendor makes the watcher watch vendor directories, which are skipped by default as
+// go test ./... doesn't test them. They are watched even if .gitignore ignores them, e.g. to work
+// on a vendored fork of a dependency.
+func (tw *TestWatcher) SetIncludeVendor(enabled bool) {
+	tw.includeVendor = enabled
+}
+
+// isIgnored reports whether path or one of its parent directories is ignored.
+// Whether vendor directories are ignored only depends on SetIncludeVendor.
+func (tw *TestWatcher) isIgnored(name string, isDir bool) bool {
+	if isVendored(tw.watchDir, name) {
+		return !tw.includeVendor
+	}
+	if tw.matchesIgnoreRules(name, isDir) {
+		return true
+	}
+
+	for dir := filepath.Dir(name); insidePath(tw.watchDir, dir); dir = filepath.Dir(dir) {
+		if tw.matchesIgnoreRules(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVendored reports whether path is a vendor directory below root, or inside one
+func isVendored(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || outsideRel(rel) {
+		return false
+	}
+	return slices.Contains(strings.Split(filepath.ToSlash(rel), "/"), "vendor")
+}
+
+// matchesIgnoreRules applies the ignore rules to path, where the last matching rule wins. The rules of
+// .gitignore files in deeper directories come later, followed by the SetIgnorePatterns rules.
+func (tw *TestWatcher) matchesIgnoreRules(name string, isDir bool) bool {
+	var ruleSets [][]ignoreRule
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if rules, ok := tw.gitignoreRules[dir]; ok {
+			ruleSets = append(ruleSets, rules)
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	slices.Reverse(ruleSets)
+
+	ignored := false
+	for _, rules := range append(ruleSets, tw.ignoreRules) {
+		for _, rule := range rules {
+			if rule.matches(name, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// isExcluded reports whether path matches one of the exclude patterns. Patterns are matched
+// against the base name and the path relative to the watch directory of path and its parent
+// directories, so "testdata" and "testdata/*" both exclude everything below testdata.
+func (tw *TestWatcher) isExcluded(name string) bool {
+	if len(tw.excludePatterns) == 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(tw.watchDir, name)
+	if err != nil || rel == "." || outsideRel(rel) {
+		return false
+	}
+
+	for candidate := filepath.ToSlash(rel); candidate != "."; candidate = path.Dir(candidate) {
+		for _, pattern := range tw.excludePatterns {
+			dirPattern := strings.TrimSuffix(strings.TrimSuffix(pattern, "/*"), "/")
+			for _, p := range []string{pattern, dirPattern} {
+				if matched, _ := path.Match(p, candidate); matched {
+					return true
+				}
+				if matched, _ := path.Match(p, path.Base(candidate)); matched {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
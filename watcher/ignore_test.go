@@ -0,0 +1,173 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+
+	tests := []struct {
+		line string
+		want ignoreRule
+		ok   bool
+	}{
+		{line: ""},
+		{line: "# comment"},
+		{line: "/"},
+		{line: "*.log  ", want: ignoreRule{base: base, segments: []string{"*.log"}}, ok: true},
+		{line: "!keep.log", want: ignoreRule{base: base, segments: []string{"keep.log"}, negate: true}, ok: true},
+		{line: `\!bang`, want: ignoreRule{base: base, segments: []string{"!bang"}}, ok: true},
+		{line: `\#hash`, want: ignoreRule{base: base, segments: []string{"#hash"}}, ok: true},
+		{line: "tmp/", want: ignoreRule{base: base, segments: []string{"tmp"}, dirOnly: true}, ok: true},
+		{line: "/build", want: ignoreRule{base: base, segments: []string{"build"}, anchored: true}, ok: true},
+		{line: "docs/**/*.md", want: ignoreRule{base: base, segments: []string{"docs", "**", "*.md"}, anchored: true}, ok: true},
+		{line: "!/out/", want: ignoreRule{base: base, segments: []string{"out"}, negate: true, dirOnly: true, anchored: true}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := parseIgnoreRule(base, tt.line)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIgnoreRule(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "build", name: "build", want: true},
+		{pattern: "build", name: "build/out", want: false},
+		{pattern: "calc/*.go", name: "calc/add.go", want: true},
+		{pattern: "calc/*.go", name: "calc/sub/add.go", want: false},
+		{pattern: "**/gen", name: "gen", want: true},
+		{pattern: "**/gen", name: "a/b/gen", want: true},
+		{pattern: "docs/**/*.md", name: "docs/a.md", want: true},
+		{pattern: "docs/**/*.md", name: "docs/x/y/a.md", want: true},
+		{pattern: "docs/**/*.md", name: "docs/x/a.go", want: false},
+		{pattern: "out/**", name: "out/a/b", want: true},
+		{pattern: "out/**", name: "other/a", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/")); got != tt.want {
+				t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesIgnoreRules(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	calc := filepath.Join(root, "calc")
+	tw := &TestWatcher{watchDir: root, gitignoreRules: make(map[string][]ignoreRule)}
+	for dir, lines := range map[string][]string{
+		root: {"*.log", "!keep.log", "/build", "tmp/", "docs/**/*.md"},
+		calc: {"!debug.log"},
+	} {
+		for _, line := range lines {
+			rule, _ := parseIgnoreRule(dir, line)
+			tw.gitignoreRules[dir] = append(tw.gitignoreRules[dir], rule)
+		}
+	}
+	tw.SetIgnorePatterns([]string{"calc/skip.go", "!docs/keep.md"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "app.log", want: true},
+		{path: "keep.log", want: false},
+		{path: "calc/app.log", want: true},
+		{path: "calc/debug.log", want: false},
+		{path: "debug.log", want: true},
+		{path: "build", isDir: true, want: true},
+		{path: "calc/build", isDir: true, want: false},
+		{path: "tmp", isDir: true, want: true},
+		{path: "tmp", want: false},
+		{path: "calc/tmp", isDir: true, want: true},
+		{path: "docs/a.md", want: true},
+		{path: "docs/x/y/a.md", want: true},
+		{path: "docs/a.go", want: false},
+		{path: "docs/keep.md", want: false},
+		{path: "calc/skip.go", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			name := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := tw.matchesIgnoreRules(name, tt.isDir); got != tt.want {
+				t.Errorf("matchesIgnoreRules(%q, %v) = %v, want %v", name, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitignoreIsReloaded(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "calc")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gitignore := filepath.Join(sub, ".gitignore")
+	writeFile(t, gitignore, "*.tmp.go\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	if err := tw.addWatches(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Watching a recreated directory again replaces its rules instead of adding them twice
+	tw.removeWatches(sub)
+	if err := tw.addWatches(sub); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(tw.gitignoreRules[sub]); got != 1 {
+		t.Errorf("rules of %s after watching it again = %d, want 1", sub, got)
+	}
+
+	scratch := filepath.Join(sub, "scratch.tmp.go")
+	if !tw.isIgnored(scratch, false) {
+		t.Fatalf("%s is not ignored", scratch)
+	}
+
+	// Editing .gitignore applies its new rules to the following changes
+	writeFile(t, gitignore, "*.bak\n")
+	tw.pending.add(fsnotify.Event{Name: gitignore, Op: fsnotify.Write})
+	tw.collectChanges()
+	if tw.isIgnored(scratch, false) {
+		t.Errorf("%s is still ignored after .gitignore changed", scratch)
+	}
+	if !tw.isIgnored(filepath.Join(sub, "calc.bak"), false) {
+		t.Error("the new rule of .gitignore is not applied")
+	}
+
+	// Removing it drops its rules
+	if err := os.Remove(gitignore); err != nil {
+		t.Fatal(err)
+	}
+	tw.pending.add(fsnotify.Event{Name: gitignore, Op: fsnotify.Remove})
+	tw.collectChanges()
+	if _, ok := tw.gitignoreRules[sub]; ok {
+		t.Error("the rules of a removed .gitignore are still applied")
+	}
+}
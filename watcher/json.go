@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// testEvent is a single event of the go test -json stream, see "go doc test2json"
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// jsonRun is the outcome of a go test -json run
+type jsonRun struct {
+	result Result
+	// output is the plain text output reconstructed from the event stream
+	output string
+	// failures holds the output of each failed test
+	failures []string
+}
+
+// parseJSONEvents decodes a go test -json stream.
+// Lines that are not JSON, such as compiler errors written to stderr, are kept as plain output.
+func parseJSONEvents(stream string) jsonRun {
+	var run jsonRun
+	var output strings.Builder
+	testOutput := make(map[string]*strings.Builder)
+
+	for _, line := range strings.Split(stream, "\n") {
+		if line == "" {
+			continue
+		}
+
+		var event testEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &event) != nil {
+			output.WriteString(line + "\n")
+			continue
+		}
+
+		key := event.Package + " " + event.Test
+		switch event.Action {
+		case "output", "build-output":
+			output.WriteString(event.Output)
+			if event.Test != "" {
+				if testOutput[key] == nil {
+					testOutput[key] = &strings.Builder{}
+				}
+				testOutput[key].WriteString(event.Output)
+			}
+
+		case "pass", "fail", "skip":
+			if event.Test == "" {
+				recordPackageEvent(&run.result, event)
+				continue
+			}
+			recordTestEvent(&run.result, event)
+			if event.Action == "fail" && testOutput[key] != nil {
+				run.failures = append(run.failures, strings.TrimSpace(testOutput[key].String()))
+			}
+			delete(testOutput, key)
+
+		case "build-fail":
+			run.result.BuildFailed = true
+		}
+	}
+
+	run.output = output.String()
+	run.result.UntestedPackages = untestedPackages(run.output)
+	run.result.PackageResults = parsePackageResults(run.output)
+	run.result.Benchmarks = parseBenchmarks(run.output)
+	if report, ok := parseTimeout(run.output); ok {
+		run.result.TimedOut = max(len(report.tests), 1)
+	}
+	return run
+}
+
+// recordPackageEvent adds the outcome of a package to result
+func recordPackageEvent(result *Result, event testEvent) {
+	if event.Action == "skip" {
+		return
+	}
+	result.Packages = append(result.Packages, event.Package)
+	result.Duration += time.Duration(event.Elapsed * float64(time.Second))
+	if event.Action == "fail" {
+		result.FailedPackages = append(result.FailedPackages, event.Package)
+	}
+}
+
+// recordTestEvent adds the outcome of a test to result
+func recordTestEvent(result *Result, event testEvent) {
+	if event.Action != "skip" && !strings.Contains(event.Test, "/") {
+		if result.TestDurations == nil {
+			result.TestDurations = make(map[string]time.Duration)
+		}
+		result.TestDurations[event.Package+"/"+event.Test] = time.Duration(event.Elapsed * float64(time.Second))
+	}
+
+	switch event.Action {
+	case "pass":
+		result.Passed++
+	case "skip":
+		result.Skipped++
+	case "fail":
+		result.Failed++
+		result.FailedTests = append(result.FailedTests, event.Package+"/"+event.Test)
+	}
+}
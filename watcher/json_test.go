@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJSONEvents(t *testing.T) {
+	stream := `{"Action":"start","Package":"example.com/calc"}
+{"Action":"run","Package":"example.com/calc","Test":"TestAdd"}
+{"Action":"output","Package":"example.com/calc","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}
+{"Action":"output","Package":"example.com/calc","Test":"TestAdd","Output":"--- PASS: TestAdd (0.00s)\n"}
+{"Action":"pass","Package":"example.com/calc","Test":"TestAdd","Elapsed":0}
+{"Action":"run","Package":"example.com/calc","Test":"TestSub"}
+{"Action":"output","Package":"example.com/calc","Test":"TestSub","Output":"=== RUN   TestSub\n"}
+{"Action":"output","Package":"example.com/calc","Test":"TestSub","Output":"    calc_test.go:12: --- FAIL looks odd here, want 1, got 2\n"}
+{"Action":"output","Package":"example.com/calc","Test":"TestSub","Output":"--- FAIL: TestSub (0.00s)\n"}
+{"Action":"fail","Package":"example.com/calc","Test":"TestSub","Elapsed":0}
+{"Action":"output","Package":"example.com/calc","Output":"FAIL\texample.com/calc\t0.250s\n"}
+{"Action":"fail","Package":"example.com/calc","Elapsed":0.25}
+{"Action":"pass","Package":"example.com/strings","Elapsed":0.1}
+`
+	run := parseJSONEvents(stream)
+
+	if run.result.Passed != 1 || run.result.Failed != 1 {
+		t.Errorf("unexpected counts: passed=%d failed=%d", run.result.Passed, run.result.Failed)
+	}
+	if !slices.Equal(run.result.FailedTests, []string{"example.com/calc/TestSub"}) {
+		t.Errorf("unexpected failed tests: %v", run.result.FailedTests)
+	}
+	if !slices.Equal(run.result.FailedPackages, []string{"example.com/calc"}) {
+		t.Errorf("unexpected failed packages: %v", run.result.FailedPackages)
+	}
+	if run.result.Duration != 350*time.Millisecond {
+		t.Errorf("unexpected duration: %v", run.result.Duration)
+	}
+	if len(run.failures) != 1 || !strings.HasPrefix(run.failures[0], "=== RUN   TestSub") {
+		t.Errorf("unexpected failure sections: %q", run.failures)
+	}
+	if !strings.Contains(run.output, "FAIL\texample.com/calc\t0.250s") {
+		t.Errorf("plain output was not reconstructed: %q", run.output)
+	}
+}
+
+func TestParseJSONEventsKeepsPlainLines(t *testing.T) {
+	run := parseJSONEvents("# example.com/calc\n./calc.go:3:1: syntax error\n")
+
+	if run.output != "# example.com/calc\n./calc.go:3:1: syntax error\n" {
+		t.Errorf("unexpected output: %q", run.output)
+	}
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// keyHelp describes the keyboard controls
+const keyHelp = "Press r to re-run all tests, f to re-run failed tests, c to toggle coverage, n to show the next failure, s to list the slowest tests, p to pause or resume, q to quit."
+
+// SetKeyboardControls enables single-key commands read from stdin while watching, when stdin is a terminal
+func (tw *TestWatcher) SetKeyboardControls(enabled bool) {
+	tw.keyboardControls = enabled
+}
+
+// readKeys switches the terminal to deliver key presses immediately and sends them to the returned channel.
+// The returned function restores the terminal. Nothing is read when stdin is not a terminal.
+func (tw *TestWatcher) readKeys() (<-chan byte, func()) {
+	restore, err := enableKeyPresses(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, func() {}
+	}
+	tw.restoreTerminal = restore
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	return keys, restore
+}
+
+// runAllTests runs the tests of every package, regardless of what changed
+func (tw *TestWatcher) runAllTests() error {
+	tw.runAll = true
+	defer func() {
+		tw.runAll = false
+	}()
+	return tw.RunTests()
+}
+
+// rerunFailedTests runs only the tests that failed in previous runs
+func (tw *TestWatcher) rerunFailedTests() error {
+	if len(tw.failedTestList()) == 0 {
+		fmt.Fprintf(tw.writer, "No failed tests to re-run.\n")
+		tw.writer.Flush()
+		return nil
+	}
+
+	err := tw.runTests("", tw.failedTestRuns())
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	tw.handleOutcome(tw.lastResult)
+	return err
+}
+
+// toggleCoverage switches coverage reporting for the following runs
+func (tw *TestWatcher) toggleCoverage() {
+	tw.runMutex.Lock()
+	defer tw.runMutex.Unlock()
+
+	tw.withCoverage = !tw.withCoverage
+	state := "disabled"
+	if tw.withCoverage {
+		state = "enabled"
+	}
+	fmt.Fprintf(tw.writer, "Coverage reporting %s.\n", state)
+	tw.writer.Flush()
+}
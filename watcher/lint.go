@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// lintReport is the part of the JSON output of golangci-lint, from --out-format=json, that lists the issues
+type lintReport struct {
+	Issues []lintIssue
+}
+
+// lintIssue is an issue reported by golangci-lint
+type lintIssue struct {
+	FromLinter string
+	Text       string
+	Pos        struct {
+		Filename string
+		Line     int
+		Column   int
+	}
+}
+
+// String formats the issue as "file.go:line:col: text (linter)"
+func (issue lintIssue) String() string {
+	position := fmt.Sprintf("%s:%d", issue.Pos.Filename, issue.Pos.Line)
+	if issue.Pos.Column > 0 {
+		position += fmt.Sprintf(":%d", issue.Pos.Column)
+	}
+	if issue.FromLinter == "" {
+		return position + ": " + issue.Text
+	}
+	return fmt.Sprintf("%s: %s (%s)", position, issue.Text, issue.FromLinter)
+}
+
+// lintRuns runs the linter on the packages of runs when linting is enabled, once for each test cycle.
+// A missing linter is reported once, and checked for again on the following cycles.
+// It must be called with runMutex held, or from RunOnce.
+func (tw *TestWatcher) lintRuns(runs []rootRun) {
+	if !tw.withLint {
+		return
+	}
+	if _, err := exec.LookPath(tw.lintCommand); err != nil {
+		if !tw.lintMissingReported {
+			fmt.Fprintf(tw.writer, "Linter %q not found, skipping lint: %v\n", tw.lintCommand, err)
+			tw.writer.Flush()
+			tw.lintMissingReported = true
+		}
+		return
+	}
+	tw.lintMissingReported = false
+
+	for _, run := range runs {
+		tw.runLint(run.dir, run.packages)
+	}
+	tw.writer.Flush()
+}
+
+// runLint runs the configured linter on the given packages in dir and writes the reported issues to the writer
+func (tw *TestWatcher) runLint(dir string, packages []string) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(tw.lintCommand, append(slices.Clone(tw.lintArgs), packages...)...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	var report lintReport
+	if jsonErr := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &report); jsonErr != nil || err != nil && len(report.Issues) == 0 {
+		if err == nil {
+			// Passing output that isn't JSON, e.g. from a wrapper, has nothing to report
+			return
+		}
+		// The linter failed without reporting issues, e.g. because of a configuration error
+		output := strings.TrimSpace(stderr.String() + "\n" + stdout.String())
+		fmt.Fprintf(tw.writer, "%s\n%s\n", tw.colorize(colorYellow, "LINT FAILED:"), output)
+		return
+	}
+	if len(report.Issues) == 0 {
+		return
+	}
+
+	issues := make([]string, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		issues = append(issues, issue.String())
+	}
+	// Yellow sets lint issues apart from the red test failures
+	fmt.Fprintf(tw.writer, "%s\n\n%s\n\n", tw.colorize(colorYellow, "LINT ISSUES:"), strings.Join(issues, "\n"))
+}
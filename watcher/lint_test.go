@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintRunsOncePerCycle(t *testing.T) {
+	dir := t.TempDir()
+	goBinary, _ := fakeGo(t, `printf 'ok\texample.com/calc\t0.01s\n'`+"\n")
+	linter, calls := fakeGo(t, recordCalls+`printf '{"Issues":[{"FromLinter":"errcheck","Text":"Error return value is not checked","Pos":{"Filename":"calc/calc.go","Line":12,"Column":3}}],"Report":{}}\n'
+exit 1
+`)
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	var output strings.Builder
+	tw.SetPlainOutput(true)
+	tw.writer.SetOutput(&output)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetAlwaysRunAll(true)
+	tw.SetLintCommand(linter, []string{"run", "--out-format=json"})
+	tw.EnableLint(true)
+	tw.SetFailedFirst(true)
+	tw.TrackFailedTest("calc/TestAdd")
+
+	// The failed tests pass first, then everything is tested, and lint only runs after the latter
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := waitForCalls(t, calls, 1), "run --out-format=json ./...\n"; got != want {
+		t.Errorf("linter calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+	want := "LINT ISSUES:\n\ncalc/calc.go:12:3: Error return value is not checked (errcheck)\n"
+	if got := output.String(); !strings.Contains(got, want) {
+		t.Errorf("output:\n%s\nwant it to contain:\n%s", got, want)
+	}
+}
+
+func TestLintFailureShowsOutput(t *testing.T) {
+	dir := t.TempDir()
+	linter, _ := fakeGo(t, "echo 'level=error msg=\"unknown flag\"' >&2\nexit 3\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	var output strings.Builder
+	tw.SetPlainOutput(true)
+	tw.writer.SetOutput(&output)
+	tw.SetLintCommand(linter, []string{"run", "--out-format=json"})
+
+	tw.runLint(dir, []string{"./..."})
+	tw.writer.Flush()
+	want := "LINT FAILED:\nlevel=error msg=\"unknown flag\"\n"
+	if got := output.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMissingLinterIsReportedOnce(t *testing.T) {
+	dir := t.TempDir()
+	goBinary, _ := fakeGo(t, `printf 'ok\texample.com/calc\t0.01s\n'`+"\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	var output strings.Builder
+	tw.SetPlainOutput(true)
+	tw.writer.SetOutput(&output)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetAlwaysRunAll(true)
+	linter := filepath.Join(dir, "missing-linter")
+	tw.SetLintCommand(linter, nil)
+	tw.EnableLint(true)
+
+	for range 2 {
+		if err := tw.RunTests(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Count(output.String(), "not found"); got != 1 {
+		t.Errorf("missing linter reported %d times, want once:\n%s", got, output.String())
+	}
+	if !tw.withLint {
+		t.Error("lint was disabled by the missing linter")
+	}
+
+	// Lint resumes once the linter is installed
+	writeFile(t, linter, "#!/bin/sh\nprintf '{\"Issues\":null}\\n'\n")
+	if err := os.Chmod(linter, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if tw.lintMissingReported {
+		t.Error("the missing linter is still reported after it was installed")
+	}
+}
@@ -0,0 +1,17 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+)
+
+// newDefaultLogger returns the logger used until SetLogger is called, which reports warnings and errors to stderr
+func newDefaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+}
+
+// SetLogger sets the logger for watcher diagnostics, such as received events, debounced runs and
+// the packages selected for testing, which are logged at debug level. Test results are not logged.
+func (tw *TestWatcher) SetLogger(logger *slog.Logger) {
+	tw.logger = logger
+}
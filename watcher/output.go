@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gosuri/uilive"
+)
+
+// refreshInterval is how often the live output is redrawn
+const refreshInterval = 100 * time.Millisecond
+
+// outputWriter is where the watcher writes its terminal output. Output written between
+// flushes is one update: on a terminal each update replaces the previous one in place,
+// otherwise updates are appended as plain lines.
+type outputWriter interface {
+	io.Writer
+	Flush() error
+	Start()
+	Stop()
+	// Bypass returns a writer for output that is not part of the updates
+	Bypass() io.Writer
+	// SetOutput sets the writer the updates are written to
+	SetOutput(out io.Writer)
+}
+
+// liveWriter redraws updates in place with uilive
+type liveWriter struct {
+	*uilive.Writer
+}
+
+// newLiveWriter creates a liveWriter writing to stdout
+func newLiveWriter() *liveWriter {
+	writer := uilive.New()
+	writer.RefreshInterval = refreshInterval
+	return &liveWriter{writer}
+}
+
+// SetOutput sets the writer the updates are written to
+func (w *liveWriter) SetOutput(out io.Writer) {
+	w.Out = out
+}
+
+// plainWriter appends every update as plain lines, without cursor movement, for logs and pipes
+type plainWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+	buf   bytes.Buffer
+}
+
+// newPlainWriter creates a plainWriter writing to out
+func newPlainWriter(out io.Writer) *plainWriter {
+	return &plainWriter{out: out}
+}
+
+// Write buffers p until the next Flush
+func (w *plainWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Write(p)
+}
+
+// Flush writes the buffered output
+func (w *plainWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := w.buf.WriteTo(w.out)
+	return err
+}
+
+// Start does nothing, as output is only written when flushed
+func (w *plainWriter) Start() {}
+
+// Stop writes the buffered output
+func (w *plainWriter) Stop() {
+	w.Flush()
+}
+
+// Bypass returns the underlying writer
+func (w *plainWriter) Bypass() io.Writer {
+	return w.out
+}
+
+// SetOutput sets the writer the updates are written to
+func (w *plainWriter) SetOutput(out io.Writer) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.out = out
+}
+
+// SetPlainOutput makes the watcher append its output as plain lines instead of updating it in place,
+// for when output is not a terminal. The running timer and screen clearing are left out.
+func (tw *TestWatcher) SetPlainOutput(enabled bool) {
+	if enabled == tw.plainOutput {
+		return
+	}
+	tw.plainOutput = enabled
+
+	out := io.Writer(os.Stdout)
+	if tw.jsonOutput != nil {
+		out = os.Stderr
+	}
+	if enabled {
+		tw.writer = newPlainWriter(out)
+	} else {
+		tw.writer = newLiveWriter()
+		tw.writer.SetOutput(out)
+	}
+}
@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PackageResult is the outcome of testing a single package, from its go test summary line
+type PackageResult struct {
+	Package string
+	// Status is "ok", "FAIL", or "?" for packages without test files
+	Status   string
+	Duration time.Duration
+	Cached   bool
+	// Detail is the rest of the summary line, such as the coverage or "[build failed]"
+	Detail string
+	// Coverage is the statement coverage percentage of the package, valid when HasCoverage is set
+	Coverage    float64
+	HasCoverage bool
+}
+
+// packageCoveragePattern matches the coverage go test reports on the summary line of a package
+var packageCoveragePattern = regexp.MustCompile(`coverage: (\d+(?:\.\d+)?)% of statements`)
+
+// parsePackageResults parses the summary line go test prints for each package
+func parsePackageResults(output string) []PackageResult {
+	var results []PackageResult
+	for _, line := range strings.Split(output, "\n") {
+		// Summary lines are not indented, unlike test output
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.TrimLeft(line, " \t") != line {
+			continue
+		}
+		if fields[0] != "ok" && fields[0] != "FAIL" && fields[0] != "?" {
+			continue
+		}
+
+		result := PackageResult{Package: fields[1], Status: fields[0]}
+		rest := fields[2:]
+		if len(rest) > 0 {
+			if rest[0] == "(cached)" {
+				result.Cached = true
+				rest = rest[1:]
+			} else if duration, err := time.ParseDuration(rest[0]); err == nil {
+				result.Duration = duration
+				rest = rest[1:]
+			}
+		}
+		result.Detail = strings.Join(rest, " ")
+		if match := packageCoveragePattern.FindStringSubmatch(result.Detail); match != nil {
+			result.Coverage, _ = strconv.ParseFloat(match[1], 64)
+			result.HasCoverage = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// writePackageTable writes the result of each package as an aligned table
+func (tw *TestWatcher) writePackageTable(results []PackageResult) {
+	fmt.Fprintf(tw.writer, "PACKAGES:\n\n")
+	table := tabwriter.NewWriter(tw.writer, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		// Every status is colored, so the escape codes don't misalign the columns
+		var status string
+		switch result.Status {
+		case "ok":
+			status = tw.colorize(colorGreen, result.Status)
+		case "FAIL":
+			status = tw.colorize(colorRed, result.Status)
+		default:
+			status = tw.colorize(colorYellow, result.Status)
+		}
+
+		duration := ""
+		if result.Cached {
+			duration = "(cached)"
+		} else if result.Duration > 0 {
+			duration = fmt.Sprintf("%.3fs", result.Duration.Seconds())
+		}
+		// Leave out empty cells so lines have no trailing padding
+		cells := []string{status, result.Package}
+		for _, cell := range []string{duration, result.Detail} {
+			if cell != "" {
+				cells = append(cells, cell)
+			}
+		}
+		fmt.Fprintln(table, strings.Join(cells, "\t"))
+	}
+	table.Flush()
+	fmt.Fprintln(tw.writer)
+}
+
+// writeCoverageList writes the coverage of each package that reported one
+func (tw *TestWatcher) writeCoverageList(results []PackageResult) {
+	fmt.Fprintf(tw.writer, "COVERAGE:\n\n")
+	table := tabwriter.NewWriter(tw.writer, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%.1f%%\n", result.Package, result.Coverage)
+	}
+	table.Flush()
+	fmt.Fprintln(tw.writer)
+}
+
+// coveredPackages returns the results of the packages that reported their coverage
+func coveredPackages(results []PackageResult) []PackageResult {
+	var covered []PackageResult
+	for _, result := range results {
+		if result.HasCoverage {
+			covered = append(covered, result)
+		}
+	}
+	return covered
+}
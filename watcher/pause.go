@@ -0,0 +1,59 @@
+package watcher
+
+import "fmt"
+
+// Pause stops changes from running tests, e.g. during a large refactoring. The changes are still
+// collected, and tested together by Resume. A run in progress finishes, and runs started from the
+// keyboard are not paused.
+func (tw *TestWatcher) Pause() {
+	tw.rateMutex.Lock()
+	paused := tw.paused
+	tw.paused = true
+	tw.rateMutex.Unlock()
+	if paused {
+		return
+	}
+
+	fmt.Fprintf(tw.writer, "%s\n", tw.colorize(colorYellow, "PAUSED: changes are tested once resumed"))
+	tw.writer.Flush()
+}
+
+// Resume lets changes run tests again after Pause, and tests the changes made while paused, if any
+func (tw *TestWatcher) Resume() {
+	tw.rateMutex.Lock()
+	paused := tw.paused
+	tw.paused = false
+	tw.rateMutex.Unlock()
+	if !paused {
+		return
+	}
+
+	fmt.Fprintf(tw.writer, "Resumed. Watching for file changes.\n")
+	tw.writer.Flush()
+	go tw.runChangedTests()
+}
+
+// Paused reports whether changes are kept from running tests by Pause
+func (tw *TestWatcher) Paused() bool {
+	tw.rateMutex.Lock()
+	defer tw.rateMutex.Unlock()
+	return tw.paused
+}
+
+// togglePause pauses or resumes the watcher, for the keyboard controls
+func (tw *TestWatcher) togglePause() {
+	if tw.Paused() {
+		tw.Resume()
+	} else {
+		tw.Pause()
+	}
+}
+
+// pausedChangedRun reports whether testing changes has to wait for Resume. The changes stay pending until then.
+func (tw *TestWatcher) pausedChangedRun() bool {
+	if tw.Paused() {
+		tw.logger.Debug("run held back while paused")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultTriggerOps are the file operations that run tests unless SetTriggerOps is called
+const DefaultTriggerOps = fsnotify.Write | fsnotify.Create
+
+// triggerOpNames maps the operation names accepted by ParseTriggerOps to operations
+var triggerOpNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// ParseTriggerOps parses a comma-separated list of operation names, such as "write,create,remove",
+// into the operations for SetTriggerOps
+func ParseTriggerOps(names string) (fsnotify.Op, error) {
+	var ops fsnotify.Op
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		op, ok := triggerOpNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown operation %q, expected create, write, remove, rename or chmod", name)
+		}
+		ops |= op
+	}
+	if ops == 0 {
+		return 0, errors.New("no operations given")
+	}
+	return ops, nil
+}
+
+// pendingEvents accumulates the operations reported for each path until the next run,
+// so the bursts of events of an editor's atomic save are handled once per path
+type pendingEvents struct {
+	mutex sync.Mutex
+	ops   map[string]fsnotify.Op
+}
+
+// add records the operation of event for its path
+func (p *pendingEvents) add(event fsnotify.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.ops == nil {
+		p.ops = make(map[string]fsnotify.Op)
+	}
+	p.ops[event.Name] |= event.Op
+}
+
+// take returns the recorded operations and starts recording anew
+func (p *pendingEvents) take() map[string]fsnotify.Op {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ops := p.ops
+	p.ops = nil
+	return ops
+}
+
+// takeDir returns and forgets the recorded operations of the paths directly in dir
+func (p *pendingEvents) takeDir(dir string) map[string]fsnotify.Op {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ops := make(map[string]fsnotify.Op)
+	for path, op := range p.ops {
+		if filepath.Dir(path) == dir {
+			ops[path] = op
+			delete(p.ops, path)
+		}
+	}
+	return ops
+}
+
+// dedupWindow is how long a repeated event for the same path and operation is dropped,
+// such as the several writes of a single save
+const dedupWindow = 50 * time.Millisecond
+
+// eventDeduper drops events that repeat a recent event for the same path and operation
+type eventDeduper struct {
+	seen map[fsnotify.Event]time.Time
+}
+
+// duplicate reports whether event repeats an event received less than dedupWindow before now
+func (d *eventDeduper) duplicate(event fsnotify.Event, now time.Time) bool {
+	if d.seen == nil {
+		d.seen = make(map[fsnotify.Event]time.Time)
+	}
+	for seen, at := range d.seen {
+		if now.Sub(at) >= dedupWindow {
+			delete(d.seen, seen)
+		}
+	}
+
+	if _, ok := d.seen[event]; ok {
+		return true
+	}
+	d.seen[event] = now
+	return false
+}
+
+// handleEvent records a file event and schedules a run once events have settled,
+// for the directory of the file when each package is debounced separately
+func (tw *TestWatcher) handleEvent(event fsnotify.Event, debounce *debouncer) {
+	if tw.dedup.duplicate(event, time.Now()) {
+		return
+	}
+	tw.logger.Debug("event received", "path", event.Name, "op", event.Op.String())
+
+	// Permission changes, such as the chmod at the end of an atomic save, don't change content
+	if event.Op == fsnotify.Chmod && !tw.triggerOps.Has(fsnotify.Chmod) {
+		tw.logger.Debug("change ignored", "path", event.Name, "reason", "permission change only", "op", event.Op.String())
+		return
+	}
+	tw.pending.add(event)
+	if tw.perPackageDebounce {
+		debounce.TriggerKey(filepath.Dir(event.Name))
+		return
+	}
+	debounce.Trigger()
+}
+
+// collectChanges adds the files changed by the events recorded since the last run to the changed files.
+// Each path is judged by its final state, so temporary and backup files that are gone again are skipped.
+// It reports whether any change should run tests.
+func (tw *TestWatcher) collectChanges() bool {
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+
+	return tw.collectOps(tw.pending.take())
+}
+
+// collectDirChanges is like collectChanges, but only collects the events of the paths directly in dir
+func (tw *TestWatcher) collectDirChanges(dir string) bool {
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+
+	return tw.collectOps(tw.pending.takeDir(dir))
+}
+
+// collectOps adds the files changed by ops to the changed files, reporting whether any should run tests
+func (tw *TestWatcher) collectOps(ops map[string]fsnotify.Op) bool {
+	paths := make([]string, 0, len(ops))
+	for path := range ops {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	tw.logger.Debug("debounce fired", "paths", paths)
+
+	changed := false
+	for _, path := range paths {
+		op := ops[path]
+		// The rules of a written or removed .gitignore file apply to the following changes
+		if filepath.Base(path) == ".gitignore" {
+			tw.loadGitignore(filepath.Dir(path))
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// A removed or renamed directory must be watched again if it is recreated
+			if op.Has(fsnotify.Remove) || op.Has(fsnotify.Rename) {
+				tw.logger.Debug("path removed", "path", path, "op", op.String())
+				tw.removeWatches(path)
+				// Deleting a test file changes the results, so it runs the tests of its package
+				if tw.isTestFileChange(path) {
+					tw.dependenciesStale = true
+					tw.AddChangedFile(path)
+					changed = true
+				} else if op&tw.triggerOps&(fsnotify.Remove|fsnotify.Rename) != 0 && tw.shouldTrigger(path) {
+					tw.AddChangedFile(path)
+					changed = true
+				}
+			}
+			continue
+		}
+
+		if info.IsDir() {
+			// A directory created or renamed into place, e.g. by a branch checkout, is not watched yet.
+			// Its files may have been written before the watch was added, so they all count as changed.
+			if tw.watchedDirs[path] && !op.Has(fsnotify.Create) && !op.Has(fsnotify.Rename) {
+				continue
+			}
+			// Subdirectories of a focused package are other packages, which are not watched
+			if strings.HasPrefix(filepath.Base(path), ".") || tw.focusDir != "" {
+				continue
+			}
+			tw.removeWatches(path)
+			if err := tw.addWatches(path); err != nil {
+				tw.logger.Warn("could not watch directory", "dir", path, "err", err)
+			}
+			tw.dependenciesStale = true
+			if tw.addChangedFilesIn(path) {
+				changed = true
+			}
+			continue
+		}
+
+		// Creating a test file, e.g. to see a new failing test fail, runs its package whatever the filter
+		if op.Has(fsnotify.Create) && tw.isNewTestFile(path) {
+			tw.addTestFile(path)
+			changed = true
+			continue
+		}
+
+		if op&tw.triggerOps == 0 {
+			tw.logger.Debug("change ignored", "path", path, "reason", "operation not in the trigger operations", "op", op.String())
+			continue
+		}
+		if tw.shouldTrigger(path) {
+			tw.AddChangedFile(path)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// addChangedFilesIn adds the files below the watched directory dir that should run tests to the changed files
+func (tw *TestWatcher) addChangedFilesIn(dir string) bool {
+	changed := false
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if !tw.watchedDirs[path] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if tw.shouldTrigger(path) {
+			tw.AddChangedFile(path)
+			changed = true
+		}
+		return nil
+	})
+	return changed
+}
+
+// isNewTestFile reports whether a created file is a test file that runs tests, making sure its
+// directory is watched, as it may have been created together with the file
+func (tw *TestWatcher) isNewTestFile(path string) bool {
+	if !tw.isTestFileChange(path) {
+		return false
+	}
+
+	if dir := filepath.Dir(path); !tw.watchedDirs[dir] && tw.focusDir == "" {
+		if err := tw.addWatches(dir); err != nil {
+			tw.logger.Warn("could not watch directory", "dir", dir, "err", err)
+		}
+		tw.dependenciesStale = true
+	}
+	tw.importsChanged(path)
+	return true
+}
+
+// isTestFileChange reports whether path is a test file whose creation or removal runs tests,
+// whatever the file filter and trigger operations
+func (tw *TestWatcher) isTestFileChange(path string) bool {
+	if !strings.HasSuffix(filepath.Base(path), "_test.go") || tw.outsideFocus(path) {
+		return false
+	}
+	return !tw.isIgnored(path, false) && !tw.isExcluded(path)
+}
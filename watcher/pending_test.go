@@ -0,0 +1,251 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAtomicSaveRunsOnce(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+	temp := file + "___jb_tmp___"
+	backup := file + "___jb_old___"
+
+	tw := &TestWatcher{
+		watchDir:     dir,
+		fileFilter:   func(path string) bool { return filepath.Ext(path) == ".go" },
+		changedFiles: make(map[string]bool),
+		fileImports:  make(map[string]string),
+		logger:       slog.New(slog.DiscardHandler),
+		triggerOps:   DefaultTriggerOps,
+	}
+
+	var runs atomic.Int32
+	debounce := newDebouncer(50*time.Millisecond, func() {
+		if tw.collectChanges() {
+			runs.Add(1)
+		}
+	})
+
+	// An IDE writes a temporary file, moves the original away and renames the temporary file into place
+	writeFile(t, temp, "package calc\n")
+	os.Rename(temp, file)
+	for _, event := range []fsnotify.Event{
+		{Name: temp, Op: fsnotify.Create},
+		{Name: temp, Op: fsnotify.Write},
+		{Name: file, Op: fsnotify.Rename},
+		{Name: backup, Op: fsnotify.Create},
+		{Name: temp, Op: fsnotify.Rename},
+		{Name: file, Op: fsnotify.Create},
+		{Name: file, Op: fsnotify.Chmod},
+		{Name: backup, Op: fsnotify.Remove},
+	} {
+		tw.handleEvent(event, debounce)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("runs = %d, want 1", got)
+	}
+
+	var changed []string
+	for path := range tw.changedFiles {
+		changed = append(changed, path)
+	}
+	if !slices.Equal(changed, []string{file}) {
+		t.Errorf("changed files = %v, want [%s]", changed, file)
+	}
+}
+
+func TestChmodOnlyDoesNotRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+	writeFile(t, file, "package calc\n")
+
+	tw := &TestWatcher{
+		watchDir:     dir,
+		fileFilter:   func(path string) bool { return filepath.Ext(path) == ".go" },
+		changedFiles: make(map[string]bool),
+		fileImports:  make(map[string]string),
+		logger:       slog.New(slog.DiscardHandler),
+		triggerOps:   DefaultTriggerOps,
+	}
+
+	var runs atomic.Int32
+	debounce := newDebouncer(20*time.Millisecond, func() {
+		if tw.collectChanges() {
+			runs.Add(1)
+		}
+	})
+
+	tw.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Chmod}, debounce)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := runs.Load(); got != 0 {
+		t.Errorf("runs = %d, want 0", got)
+	}
+}
+
+func TestDuplicateEventsAddOneFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+	writeFile(t, file, "package calc\n")
+
+	tw := &TestWatcher{
+		watchDir:     dir,
+		fileFilter:   func(path string) bool { return filepath.Ext(path) == ".go" },
+		changedFiles: make(map[string]bool),
+		fileImports:  make(map[string]string),
+		logger:       slog.New(slog.DiscardHandler),
+		triggerOps:   DefaultTriggerOps,
+	}
+	debounce := newDebouncer(time.Hour, func() {})
+	defer debounce.Stop()
+
+	for range 3 {
+		tw.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Write}, debounce)
+	}
+	if got := len(tw.dedup.seen); got != 1 {
+		t.Errorf("recorded events = %d, want 1", got)
+	}
+
+	if !tw.collectChanges() {
+		t.Fatal("collectChanges() = false, want true")
+	}
+	if len(tw.changedFiles) != 1 || !tw.changedFiles[file] {
+		t.Errorf("changed files = %v, want only %s", tw.changedFiles, file)
+	}
+}
+
+func TestEventDeduperWindow(t *testing.T) {
+	var dedup eventDeduper
+	now := time.Now()
+	write := fsnotify.Event{Name: "calc.go", Op: fsnotify.Write}
+	create := fsnotify.Event{Name: "calc.go", Op: fsnotify.Create}
+
+	if dedup.duplicate(write, now) {
+		t.Error("first write reported as duplicate")
+	}
+	if !dedup.duplicate(write, now.Add(10*time.Millisecond)) {
+		t.Error("repeated write not reported as duplicate")
+	}
+	if dedup.duplicate(create, now.Add(10*time.Millisecond)) {
+		t.Error("create of the same path reported as duplicate")
+	}
+	if dedup.duplicate(write, now.Add(dedupWindow)) {
+		t.Error("write after the window reported as duplicate")
+	}
+}
+
+func TestRemoveTriggersWhenEnabled(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "calc.go")
+
+	for _, tt := range []struct {
+		name string
+		ops  fsnotify.Op
+		want bool
+	}{
+		{name: "default", ops: DefaultTriggerOps, want: false},
+		{name: "remove", ops: DefaultTriggerOps | fsnotify.Remove, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := &TestWatcher{
+				watchDir:     dir,
+				fileFilter:   func(path string) bool { return filepath.Ext(path) == ".go" },
+				changedFiles: make(map[string]bool),
+				fileImports:  make(map[string]string),
+				watchedDirs:  make(map[string]bool),
+				logger:       slog.New(slog.DiscardHandler),
+			}
+			tw.SetTriggerOps(tt.ops)
+
+			tw.pending.add(fsnotify.Event{Name: file, Op: fsnotify.Remove})
+			if got := tw.collectChanges(); got != tt.want {
+				t.Errorf("collectChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemovedTestFileRunsItsPackage(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "calc")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "calc_test.go")
+	writeFile(t, file, "package calc\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.Close()
+
+	// Removing a test file runs tests with the default trigger operations, which leave out Remove
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	tw.pending.add(fsnotify.Event{Name: file, Op: fsnotify.Remove})
+	if !tw.collectChanges() {
+		t.Fatal("collectChanges() = false, want true for a removed test file")
+	}
+	if got := tw.packagesToTest(); !slices.Equal(got, []string{"./calc"}) {
+		t.Errorf("packagesToTest() = %v, want [./calc]", got)
+	}
+}
+
+func TestNewTestFileAlwaysTriggers(t *testing.T) {
+	dir := t.TempDir()
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.Close()
+
+	// The directory is created along with the test file, after the watches were added
+	sub := filepath.Join(dir, "calc")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "calc_test.go")
+	writeFile(t, file, "package calc\n")
+	tw.SetFileFilter(func(path string) bool { return filepath.Ext(path) == ".txt" })
+	tw.SetTriggerOps(fsnotify.Write)
+	tw.SetNewTestFileOnly(true)
+
+	tw.pending.add(fsnotify.Event{Name: file, Op: fsnotify.Create})
+	if !tw.collectChanges() {
+		t.Fatal("collectChanges() = false, want true for a new test file")
+	}
+	if !tw.watchedDirs[sub] {
+		t.Errorf("directory %s of the new test file is not watched", sub)
+	}
+	if got := tw.packagesToTest(); !slices.Equal(got, []string{"./calc"}) {
+		t.Errorf("packagesToTest() = %v, want [./calc]", got)
+	}
+}
+
+func TestParseTriggerOps(t *testing.T) {
+	ops, err := ParseTriggerOps("write, Create,remove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fsnotify.Write | fsnotify.Create | fsnotify.Remove; ops != want {
+		t.Errorf("ParseTriggerOps() = %v, want %v", ops, want)
+	}
+
+	for _, names := range []string{"", "write,delete"} {
+		if _, err := ParseTriggerOps(names); err == nil {
+			t.Errorf("ParseTriggerOps(%q) succeeded", names)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+// hardTimeoutMargin is how long a test command may overrun the test timeout before it is killed.
+// It leaves go test time to report the timed out tests, and covers building the test binaries.
+const hardTimeoutMargin = time.Minute
+
+// errHardTimeout is returned when the test command overran the test timeout and was killed
+var errHardTimeout = errors.New("test command overran the test timeout")
+
+// processOutput captures the output of test processes, both per stream and combined in the order it was written.
+// go test reports test output and results on stdout, and build errors and other go command errors on stderr.
+type processOutput struct {
+	mutex    sync.Mutex
+	combined bytes.Buffer
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	// runs holds the part of stdout written by each process, whose results are parsed separately
+	runs     []processRun
+	runStart int
+}
+
+// processRun is the stdout of one test process, and the coverage profile it wrote, if any
+type processRun struct {
+	stdout       string
+	coverProfile string
+}
+
+// streamWriter writes one stream of a processOutput
+type streamWriter struct {
+	output *processOutput
+	stream *bytes.Buffer
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.output.mutex.Lock()
+	defer w.output.mutex.Unlock()
+
+	w.output.combined.Write(p)
+	return w.stream.Write(p)
+}
+
+// writers returns the writers to capture the stdout and stderr of a process with
+func (o *processOutput) writers() (io.Writer, io.Writer) {
+	return streamWriter{o, &o.stdout}, streamWriter{o, &o.stderr}
+}
+
+// endRun records the stdout written since the previous process ended as that of a process
+// that wrote the coverage profile coverProfile, or none if it is empty
+func (o *processOutput) endRun(coverProfile string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.runs = append(o.runs, processRun{stdout: o.stdout.String()[o.runStart:], coverProfile: coverProfile})
+	o.runStart = o.stdout.Len()
+}
+
+// SetEnv sets environment variables for the test process, such as CGO_ENABLED=0 or the URL of a
+// test database. They override the variables of the same name, and the rest of the environment
+// is still inherited.
+func (tw *TestWatcher) SetEnv(env map[string]string) {
+	tw.env = maps.Clone(env)
+}
+
+// envList returns the variables set with SetEnv as sorted KEY=VALUE pairs
+func (tw *TestWatcher) envList() []string {
+	list := make([]string, 0, len(tw.env))
+	for _, key := range slices.Sorted(maps.Keys(tw.env)) {
+		list = append(list, key+"="+tw.env[key])
+	}
+	return list
+}
+
+// startCancelableRun returns the context of a test run, which may start several test processes, and
+// publishes it so terminateTests can cancel the run as a whole. The returned function ends the run.
+func (tw *TestWatcher) startCancelableRun() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	tw.processMutex.Lock()
+	tw.cancelRun = cancel
+	tw.runningDone = done
+	tw.processMutex.Unlock()
+
+	return ctx, func() {
+		tw.processMutex.Lock()
+		tw.cancelRun = nil
+		tw.runningDone = nil
+		tw.processMutex.Unlock()
+		cancel()
+		close(done)
+	}
+}
+
+// runTestProcess runs the test command with args in dir, in its own process group.
+// When the run of ctx is cancelled with terminateTests, context.Canceled is returned, also
+// without starting the process, and errHardTimeout when it is killed for overrunning the test timeout.
+func (tw *TestWatcher) runTestProcess(ctx context.Context, dir string, args []string, stdout, stderr io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var cancel context.CancelFunc
+	if tw.timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, tw.timeout+hardTimeoutMargin, errHardTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var container string
+	if tw.dockerImage != "" {
+		container = newContainerName()
+	}
+	name, args := tw.processCommand(dir, container, args)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	if len(tw.env) > 0 {
+		// Later values win, so the variables override the inherited ones
+		cmd.Env = append(os.Environ(), tw.envList()...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	// Interrupt first so tests can clean up; Wait kills the process once the grace period is over
+	cmd.Cancel = func() error {
+		if container != "" {
+			signalContainer(container, "INT")
+		}
+		return interruptProcessGroup(cmd)
+	}
+	cmd.WaitDelay = tw.killGracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		// Don't leave processes spawned by the tests behind
+		killProcessGroup(cmd)
+		if container != "" {
+			// The container is already gone if it stopped when interrupted
+			signalContainer(container, "KILL")
+		}
+		if errors.Is(context.Cause(ctx), errHardTimeout) {
+			return errHardTimeout
+		}
+		return context.Canceled
+	}
+	return err
+}
+
+// terminateTests cancels the running test run and waits for it to end.
+// The process group of its current process is interrupted so tests can clean up,
+// and killed if it has not exited within the grace period. Later processes of the
+// run are not started.
+func (tw *TestWatcher) terminateTests() {
+	tw.processMutex.Lock()
+	cancel, done := tw.cancelRun, tw.runningDone
+	tw.processMutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// showElapsed keeps the "Running tests..." line, followed by details, updated with the time
+// spent running tests at every refresh of the writer. The returned function stops updating
+// and returns once the last update is written.
+func (tw *TestWatcher) showElapsed(details string) func() {
+	// Plain output would get a line for every update
+	if tw.plainOutput {
+		return func() {}
+	}
+
+	start := time.Now()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(tw.writer, "Running tests... %.1fs\n%s", time.Since(start).Seconds(), details)
+				tw.writer.Flush()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package watcher
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows, where process groups cannot be signaled
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup kills the process started by cmd, as Windows cannot deliver SIGINT to it
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills the process started by cmd
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"time"
+)
+
+// SetMinInterval sets the minimum time between the end of a test run and the start of the next one
+// triggered by changes (0 disables). Changes made in between are collected and tested together once
+// the interval has passed. Runs started from the keyboard are not delayed.
+func (tw *TestWatcher) SetMinInterval(interval time.Duration) {
+	tw.minInterval = interval
+}
+
+// deferChangedRun reports whether testing changes has to wait for the minimum interval, and if so
+// makes sure a run is scheduled for when it has passed. The changes stay pending until then.
+func (tw *TestWatcher) deferChangedRun() bool {
+	if tw.minInterval <= 0 {
+		return false
+	}
+
+	tw.rateMutex.Lock()
+	defer tw.rateMutex.Unlock()
+
+	if tw.delayedRun != nil {
+		// The scheduled run collects these changes as well
+		return true
+	}
+	if tw.runInProgress {
+		// Rather than cancelling the run, the changes are tested the minimum interval after it ends
+		tw.runAfterCurrent = true
+		return true
+	}
+
+	wait := tw.minInterval - time.Since(tw.lastRunEnd)
+	if wait <= 0 {
+		return false
+	}
+	tw.logger.Debug("run delayed by the minimum interval", "wait", wait)
+	tw.scheduleChangedRun(wait)
+	return true
+}
+
+// scheduleChangedRun tests the pending changes after wait. It must be called with rateMutex held.
+func (tw *TestWatcher) scheduleChangedRun(wait time.Duration) {
+	tw.delayedRun = time.AfterFunc(wait, func() {
+		tw.rateMutex.Lock()
+		tw.delayedRun = nil
+		tw.rateMutex.Unlock()
+		tw.runChangedTests()
+	})
+}
+
+// startRun records that a test run started, for the minimum interval between runs and the session statistics
+func (tw *TestWatcher) startRun() {
+	tw.rateMutex.Lock()
+	defer tw.rateMutex.Unlock()
+	tw.runInProgress = true
+	tw.runStart = time.Now()
+}
+
+// endRun records that a test run ended, and schedules the changes made during the run
+func (tw *TestWatcher) endRun() {
+	tw.rateMutex.Lock()
+	defer tw.rateMutex.Unlock()
+
+	tw.runInProgress = false
+	tw.lastRunEnd = time.Now()
+	tw.recordTestTime(tw.lastRunEnd.Sub(tw.runStart))
+	if tw.runAfterCurrent {
+		tw.runAfterCurrent = false
+		if tw.delayedRun == nil {
+			tw.scheduleChangedRun(tw.minInterval)
+		}
+	}
+}
+
+// stopDelayedRun cancels a run waiting for the minimum interval
+func (tw *TestWatcher) stopDelayedRun() {
+	tw.rateMutex.Lock()
+	defer tw.rateMutex.Unlock()
+
+	if tw.delayedRun != nil {
+		tw.delayedRun.Stop()
+		tw.delayedRun = nil
+	}
+}
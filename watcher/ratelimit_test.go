@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestDeferChangedRun(t *testing.T) {
+	tw := &TestWatcher{logger: slog.New(slog.DiscardHandler)}
+	if tw.deferChangedRun() {
+		t.Fatal("run deferred without a minimum interval")
+	}
+
+	tw.SetMinInterval(time.Hour)
+	defer tw.stopDelayedRun()
+	if tw.deferChangedRun() {
+		t.Fatal("first run deferred")
+	}
+
+	// Changes during a run wait for it to end instead of cancelling it
+	tw.startRun()
+	if !tw.deferChangedRun() {
+		t.Fatal("run not deferred while tests are running")
+	}
+	if tw.delayedRun != nil {
+		t.Fatal("run scheduled before the running tests ended")
+	}
+	tw.endRun()
+	if tw.delayedRun == nil {
+		t.Fatal("changes made during the run were not scheduled")
+	}
+
+	// Later changes join the scheduled run
+	delayedRun := tw.delayedRun
+	if !tw.deferChangedRun() || tw.delayedRun != delayedRun {
+		t.Error("changes within the interval did not join the scheduled run")
+	}
+
+	tw.stopDelayedRun()
+	tw.lastRunEnd = time.Now().Add(-2 * time.Hour)
+	if tw.deferChangedRun() {
+		t.Error("run deferred after the interval passed")
+	}
+}
@@ -0,0 +1,99 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// RunReport is the JSON object written for every completed run when SetJSONOutput is used.
+// Each report is written on its own line, and fields are only ever added to the schema.
+type RunReport struct {
+	// Time is when the run completed
+	Time time.Time `json:"time"`
+	// Outcome is one of "PASS", "FAIL", "TIMEOUT" or "BUILD_FAILED"
+	Outcome Outcome `json:"outcome"`
+	// ChangedFiles lists the absolute paths of the files whose changes triggered the run
+	ChangedFiles []string `json:"changedFiles"`
+	// Packages lists the import paths of the tested packages
+	Packages []string `json:"packages"`
+	// Passed, Failed and Skipped count tests, including subtests.
+	// Passing tests are only counted when tests run with -v or -json.
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	// Failures lists the failed tests
+	Failures []TestFailure `json:"failures"`
+	// Flaky lists the tests that failed and then passed on a retry as "importpath/TestName",
+	// present when flaky retries are enabled
+	Flaky []string `json:"flaky,omitempty"`
+	// DurationSeconds is the time spent testing as reported by go test
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Coverage is the total statement coverage percentage, present when total coverage is enabled
+	Coverage *float64 `json:"coverage,omitempty"`
+}
+
+// TestFailure identifies a failed test in a RunReport
+type TestFailure struct {
+	// Package is the import path of the test's package, empty if it could not be determined
+	Package string `json:"package"`
+	// Test is the test name, with subtests separated by "/"
+	Test string `json:"test"`
+}
+
+// SetJSONOutput makes the watcher write a RunReport line to output after every completed run.
+// The terminal output is moved to stderr so output only contains JSON.
+func (tw *TestWatcher) SetJSONOutput(output io.Writer) {
+	tw.jsonOutput = output
+	if output != nil {
+		tw.writer.SetOutput(os.Stderr)
+	}
+}
+
+// newRunReport builds the report of a completed run
+func (tw *TestWatcher) newRunReport(result Result) RunReport {
+	report := RunReport{
+		Time:            time.Now(),
+		Outcome:         result.Outcome,
+		ChangedFiles:    []string{},
+		Packages:        slices.Clone(result.Packages),
+		Passed:          result.Passed,
+		Failed:          result.Failed,
+		Skipped:         result.Skipped,
+		Failures:        []TestFailure{},
+		Flaky:           slices.Clone(result.Flaky),
+		DurationSeconds: result.Duration.Seconds(),
+	}
+	if report.Packages == nil {
+		report.Packages = []string{}
+	}
+
+	// Runs without changes, such as the initial run, still report an empty array
+	report.ChangedFiles = append(report.ChangedFiles, tw.changedFileList()...)
+
+	for _, test := range result.FailedTests {
+		pkg, name := splitQualifiedTest(test, result.FailedPackages)
+		report.Failures = append(report.Failures, TestFailure{Package: pkg, Test: name})
+	}
+
+	if result.HasCoverage {
+		coverage := result.Coverage
+		report.Coverage = &coverage
+	}
+	return report
+}
+
+// writeRunReport writes the report of a completed run to the JSON output
+func (tw *TestWatcher) writeRunReport(result Result) {
+	data, err := json.Marshal(tw.newRunReport(result))
+	if err != nil {
+		fmt.Fprintf(tw.writer, "Could not encode run report: %v\n", err)
+		return
+	}
+	if _, err := fmt.Fprintf(tw.jsonOutput, "%s\n", data); err != nil {
+		fmt.Fprintf(tw.writer, "Could not write run report: %v\n", err)
+	}
+}
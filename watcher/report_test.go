@@ -0,0 +1,36 @@
+package watcher
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunReportWithoutChangedFiles(t *testing.T) {
+	goBinary, _ := fakeGo(t, `printf 'ok\texample.com/calc\t0.01s\n'`+"\n")
+
+	tw, err := NewTestWatcher(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	var report strings.Builder
+	tw.SetJSONOutput(&report)
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+
+	// Like the initial run, nothing changed, which is reported as an empty array rather than null
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(report.String()), &fields); err != nil {
+		t.Fatalf("report %q: %v", report.String(), err)
+	}
+	for _, field := range []string{"changedFiles", "failures"} {
+		if got := string(fields[field]); got != "[]" {
+			t.Errorf("%s = %s, want []", field, got)
+		}
+	}
+}
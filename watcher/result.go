@@ -0,0 +1,245 @@
+package watcher
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Outcome is the overall outcome of a test run
+type Outcome string
+
+const (
+	OutcomePassed      Outcome = "PASS"
+	OutcomeFailed      Outcome = "FAIL"
+	OutcomeTimedOut    Outcome = "TIMEOUT"
+	OutcomeBuildFailed Outcome = "BUILD_FAILED"
+)
+
+// severity orders outcomes from best to worst
+func (o Outcome) severity() int {
+	switch o {
+	case OutcomePassed:
+		return 1
+	case OutcomeFailed:
+		return 2
+	case OutcomeTimedOut:
+		return 3
+	case OutcomeBuildFailed:
+		return 4
+	}
+	return 0
+}
+
+// Result summarizes the outcome of a test run
+type Result struct {
+	Outcome Outcome
+	// Packages lists the packages that were tested
+	Packages []string
+	// FailedPackages lists the packages that had failing tests or did not build
+	FailedPackages []string
+	// FailedTests lists the tests that failed as "importpath/TestName"
+	FailedTests []string
+	// Flaky lists the tests that failed and then passed on a retry, as "importpath/TestName"
+	Flaky   []string
+	Passed  int
+	Failed  int
+	Skipped int
+	// TimedOut counts the tests that were aborted by the go test timeout
+	TimedOut    int
+	Duration    time.Duration
+	BuildFailed bool
+	// Coverage is the statement coverage percentage, valid when HasCoverage is set
+	Coverage    float64
+	HasCoverage bool
+	// CoverProfiles lists the coverage profiles written by the run, used to merge coverage exactly.
+	// The watcher removes the profiles it creates once the run has been reported.
+	CoverProfiles []string
+	// Benchmarks holds the results of the benchmarks that ran
+	Benchmarks []Benchmark
+	// PackageResults holds the result of each tested package
+	PackageResults []PackageResult
+	// UntestedPackages lists the tested packages without test files, which are left out of the coverage
+	UntestedPackages []string
+	// UncoveredPackages lists the packages in the coverage profiles without a single covered statement
+	UncoveredPackages []string
+	// TestDurations holds the elapsed time of each top-level test that passed or failed,
+	// keyed by "importpath/TestName"
+	TestDurations map[string]time.Duration
+}
+
+// buildFailedPattern matches the line go test prints for a package that did not build or whose setup
+// failed, such as "FAIL	example.com/calc [build failed]". Only whole lines match, so tests logging
+// the phrase are not mistaken for it.
+var buildFailedPattern = regexp.MustCompile(`^FAIL\t\S+ \[(build|setup) failed\]\r?$`)
+
+// parseTextResult builds a Result from plain go test output
+func parseTextResult(output string) Result {
+	var result Result
+	// Failed tests are listed before the FAIL line of their package
+	var pendingFailures []string
+	// So are the durations of the tests
+	pendingDurations := make(map[string]time.Duration)
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		fields := strings.Fields(trimmed)
+
+		// Subtests are indented below their test
+		if strings.HasPrefix(line, "--- PASS:") || strings.HasPrefix(line, "--- FAIL:") {
+			if duration, ok := parseTestDuration(fields); ok {
+				pendingDurations[fields[2]] = duration
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "--- PASS:"):
+			result.Passed++
+		case strings.HasPrefix(trimmed, "--- SKIP:"):
+			result.Skipped++
+		case strings.HasPrefix(trimmed, "--- FAIL:"):
+			result.Failed++
+			if len(fields) >= 3 {
+				pendingFailures = append(pendingFailures, fields[2])
+			}
+		case len(fields) >= 2 && fields[0] == "ok":
+			addTestDurations(&result, fields[1], pendingDurations)
+			pendingFailures = nil
+			result.Packages = append(result.Packages, fields[1])
+			result.Duration += parsePackageDuration(fields)
+		case len(fields) >= 2 && fields[0] == "FAIL":
+			for _, test := range pendingFailures {
+				result.FailedTests = append(result.FailedTests, fields[1]+"/"+test)
+			}
+			addTestDurations(&result, fields[1], pendingDurations)
+			pendingFailures = nil
+			result.Packages = append(result.Packages, fields[1])
+			result.FailedPackages = append(result.FailedPackages, fields[1])
+			result.Duration += parsePackageDuration(fields)
+			if buildFailedPattern.MatchString(line) {
+				result.BuildFailed = true
+			}
+		}
+	}
+
+	// Output without package lines, e.g. from a custom test command, leaves failures unqualified
+	result.FailedTests = append(result.FailedTests, pendingFailures...)
+	result.UntestedPackages = untestedPackages(output)
+	result.PackageResults = parsePackageResults(output)
+	result.Benchmarks = parseBenchmarks(output)
+
+	if report, ok := parseTimeout(output); ok {
+		result.TimedOut = max(len(report.tests), 1)
+	}
+
+	return result
+}
+
+// parseTestDuration returns the elapsed time reported on a "--- PASS: TestName (0.12s)" line
+func parseTestDuration(fields []string) (time.Duration, bool) {
+	if len(fields) < 4 {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(strings.Trim(fields[3], "()"))
+	return duration, err == nil
+}
+
+// addTestDurations records the durations of the tests of pkg in result, and forgets them in durations
+func addTestDurations(result *Result, pkg string, durations map[string]time.Duration) {
+	for test, duration := range durations {
+		if result.TestDurations == nil {
+			result.TestDurations = make(map[string]time.Duration)
+		}
+		result.TestDurations[pkg+"/"+test] = duration
+		delete(durations, test)
+	}
+}
+
+// parsePackageDuration returns the elapsed time reported on an "ok" or "FAIL" package line
+func parsePackageDuration(fields []string) time.Duration {
+	if len(fields) < 3 {
+		return 0
+	}
+	duration, err := time.ParseDuration(fields[2])
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// MergeResults combines the results of several go test invocations into one summary, such as the
+// invocations of a run for each root, batch of packages or failed test pattern. Counts and durations
+// are summed, and coverage is recomputed from the coverage profiles when every result has them
+// rather than averaged across packages.
+func MergeResults(results ...Result) Result {
+	var merged Result
+
+	for _, result := range results {
+		if result.Outcome.severity() > merged.Outcome.severity() {
+			merged.Outcome = result.Outcome
+		}
+		merged.Packages = append(merged.Packages, result.Packages...)
+		merged.FailedPackages = append(merged.FailedPackages, result.FailedPackages...)
+		merged.FailedTests = append(merged.FailedTests, result.FailedTests...)
+		merged.PackageResults = append(merged.PackageResults, result.PackageResults...)
+		merged.Flaky = append(merged.Flaky, result.Flaky...)
+		merged.Passed += result.Passed
+		merged.Failed += result.Failed
+		merged.Skipped += result.Skipped
+		merged.TimedOut += result.TimedOut
+		merged.Duration += result.Duration
+		merged.BuildFailed = merged.BuildFailed || result.BuildFailed
+		merged.CoverProfiles = append(merged.CoverProfiles, result.CoverProfiles...)
+		merged.UntestedPackages = append(merged.UntestedPackages, result.UntestedPackages...)
+		merged.Benchmarks = append(merged.Benchmarks, result.Benchmarks...)
+		for test, duration := range result.TestDurations {
+			if merged.TestDurations == nil {
+				merged.TestDurations = make(map[string]time.Duration)
+			}
+			merged.TestDurations[test] = duration
+		}
+	}
+
+	merged.Packages = uniqueSorted(merged.Packages)
+	merged.FailedPackages = uniqueSorted(merged.FailedPackages)
+	merged.FailedTests = uniqueSorted(merged.FailedTests)
+	merged.UntestedPackages = uniqueSorted(merged.UntestedPackages)
+
+	if allHaveCoverProfiles(results) {
+		if coverage, err := totalCoverage(merged.UntestedPackages, merged.CoverProfiles...); err == nil {
+			merged.Coverage = coverage
+			merged.HasCoverage = true
+		}
+		merged.UncoveredPackages, _ = uncoveredPackages(merged.CoverProfiles...)
+	} else if len(results) == 1 {
+		merged.Coverage = results[0].Coverage
+		merged.HasCoverage = results[0].HasCoverage
+		merged.UncoveredPackages = results[0].UncoveredPackages
+	}
+
+	return merged
+}
+
+// allHaveCoverProfiles reports whether every result recorded at least one coverage profile
+func allHaveCoverProfiles(results []Result) bool {
+	if len(results) == 0 {
+		return false
+	}
+	for _, result := range results {
+		if len(result.CoverProfiles) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueSorted returns the sorted distinct values of values
+func uniqueSorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
@@ -0,0 +1,374 @@
+package watcher
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTextResult(t *testing.T) {
+	output := `=== RUN   TestAdd
+--- PASS: TestAdd (0.00s)
+=== RUN   TestSub
+    calc_test.go:12: want 1, got 2
+--- FAIL: TestSub (0.00s)
+=== RUN   TestSkip
+--- SKIP: TestSkip (0.00s)
+FAIL
+FAIL	example.com/calc	0.250s
+ok  	example.com/strings	0.100s
+`
+	result := parseTextResult(output)
+
+	if result.Passed != 1 || result.Failed != 1 || result.Skipped != 1 {
+		t.Errorf("unexpected counts: passed=%d failed=%d skipped=%d", result.Passed, result.Failed, result.Skipped)
+	}
+	if !slices.Equal(result.FailedTests, []string{"example.com/calc/TestSub"}) {
+		t.Errorf("unexpected failed tests: %v", result.FailedTests)
+	}
+	if !slices.Equal(result.FailedPackages, []string{"example.com/calc"}) {
+		t.Errorf("unexpected failed packages: %v", result.FailedPackages)
+	}
+	if result.Duration != 350*time.Millisecond {
+		t.Errorf("unexpected duration: %v", result.Duration)
+	}
+}
+
+func TestParseTextResultBuildFailed(t *testing.T) {
+	output := `FAIL	example.com/calc [build failed]
+FAIL	example.com/api [setup failed]
+FAIL
+`
+	if result := parseTextResult(output); !result.BuildFailed {
+		t.Error("BuildFailed = false for packages that did not build")
+	}
+
+	// A test printing the phrase fails, but its package built
+	output = `=== RUN   TestBuild
+    build_test.go:14: FAIL	example.com/app [build failed]
+generating example.com/app: [build failed]
+--- FAIL: TestBuild (0.02s)
+FAIL
+FAIL	example.com/build	0.031s
+`
+	result := parseTextResult(output)
+	if result.BuildFailed {
+		t.Error("BuildFailed = true for a test that logged \"[build failed]\"")
+	}
+	if !slices.Equal(result.FailedTests, []string{"example.com/build/TestBuild"}) {
+		t.Errorf("unexpected failed tests: %v", result.FailedTests)
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.out")
+	second := filepath.Join(dir, "second.out")
+	writeFile(t, first, "mode: set\nexample.com/a/a.go:1.1,3.2 3 1\nexample.com/a/a.go:4.1,6.2 1 0\n")
+	writeFile(t, second, "mode: set\nexample.com/b/b.go:1.1,3.2 4 0\n")
+
+	merged := MergeResults(
+		Result{
+			Packages:      []string{"example.com/a"},
+			Passed:        2,
+			Duration:      time.Second,
+			CoverProfiles: []string{first},
+		},
+		Result{
+			Packages:       []string{"example.com/b", "example.com/a"},
+			FailedPackages: []string{"example.com/b"},
+			FailedTests:    []string{"TestB"},
+			Passed:         1,
+			Failed:         1,
+			Duration:       2 * time.Second,
+			CoverProfiles:  []string{second},
+		},
+	)
+
+	if merged.Passed != 3 || merged.Failed != 1 {
+		t.Errorf("unexpected counts: passed=%d failed=%d", merged.Passed, merged.Failed)
+	}
+	if !slices.Equal(merged.Packages, []string{"example.com/a", "example.com/b"}) {
+		t.Errorf("unexpected packages: %v", merged.Packages)
+	}
+	if !slices.Equal(merged.FailedTests, []string{"TestB"}) {
+		t.Errorf("unexpected failed tests: %v", merged.FailedTests)
+	}
+	if merged.Duration != 3*time.Second {
+		t.Errorf("unexpected duration: %v", merged.Duration)
+	}
+	if !merged.HasCoverage || merged.Coverage != 37.5 {
+		t.Errorf("unexpected coverage: %v (has coverage: %v)", merged.Coverage, merged.HasCoverage)
+	}
+}
+
+func TestMergeResultsWithoutProfiles(t *testing.T) {
+	merged := MergeResults(
+		Result{Coverage: 80, HasCoverage: true},
+		Result{Coverage: 20, HasCoverage: true},
+	)
+
+	if merged.HasCoverage {
+		t.Errorf("coverage should not be averaged without profiles, got %v", merged.Coverage)
+	}
+}
+
+func TestRunMergesResultsOfEachProcess(t *testing.T) {
+	// Each process writes a coverage profile of its package, covered only for calc
+	goBinary, _ := fakeGo(t, `for arg; do
+	case "$arg" in
+	-coverprofile=*) profile="${arg#-coverprofile=}" ;;
+	./*) pkg="${arg#./}" ;;
+	esac
+done
+count=0
+[ "$pkg" = calc ] && count=1
+printf 'mode: set\nexample.com/%s/%s.go:1.1,3.2 2 %s\n' "$pkg" "$pkg" "$count" > "$profile"
+printf -- '--- PASS: TestOne (0.00s)\nok  \texample.com/%s\t0.01s\n' "$pkg"
+`)
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+	tw.EnableCoverage(true)
+	tw.SetBatchSize(1)
+	tw.AddChangedFile(filepath.Join(dir, "calc", "calc.go"))
+	tw.AddChangedFile(filepath.Join(dir, "api", "api.go"))
+
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := tw.LastResult()
+	if !slices.Equal(result.Packages, []string{"example.com/api", "example.com/calc"}) || result.Passed != 2 {
+		t.Errorf("packages = %v, passed = %d, want both packages with a passing test each", result.Packages, result.Passed)
+	}
+	if len(result.CoverProfiles) != 2 || !result.HasCoverage || result.Coverage != 50 {
+		t.Errorf("coverage = %v from profiles %v, want 50 merged from both processes", result.Coverage, result.CoverProfiles)
+	}
+}
+
+func TestTotalCoverageSkipsUntestedPackages(t *testing.T) {
+	output := "ok  \texample.com/a\t0.01s\tcoverage: 75.0% of statements\n" +
+		"\texample.com/b\t\tcoverage: 0.0% of statements\n" +
+		"?   \texample.com/c\t[no test files]\n"
+
+	untested := untestedPackages(output)
+	if !slices.Equal(untested, []string{"example.com/b", "example.com/c"}) {
+		t.Fatalf("unexpected untested packages: %v", untested)
+	}
+
+	profile := filepath.Join(t.TempDir(), "cover.out")
+	writeFile(t, profile, "mode: set\nexample.com/a/a.go:1.1,3.2 3 1\nexample.com/a/a.go:4.1,6.2 1 0\nexample.com/b/b.go:1.1,3.2 4 0\n")
+
+	coverage, err := totalCoverage(untested, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverage != 75 {
+		t.Errorf("unexpected coverage: %v", coverage)
+	}
+}
+
+func TestCoverPackagesCountsUntestedPackages(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "cover.out")
+	writeFile(t, profile, "mode: set\nexample.com/a/a.go:1.1,3.2 3 1\nexample.com/a/a.go:4.1,6.2 1 0\nexample.com/b/b.go:1.1,3.2 4 0\n")
+
+	// With -coverpkg the statements of packages without tests are part of the total
+	coverage, err := totalCoverage(nil, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverage != 37.5 {
+		t.Errorf("unexpected coverage: %v", coverage)
+	}
+
+	uncovered, err := uncoveredPackages(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(uncovered, []string{"example.com/b"}) {
+		t.Errorf("unexpected uncovered packages: %v", uncovered)
+	}
+}
+
+func TestPackageCoverageOfSeveralPackages(t *testing.T) {
+	output := "ok  \texample.com/calc/parse\t0.012s\tcoverage: 80.0% of statements\n" +
+		"ok  \texample.com/calc/format\t(cached)\tcoverage: 42.5% of statements\n"
+
+	tw := &TestWatcher{withCoverage: true, failedTests: make(map[string]bool)}
+	var buf strings.Builder
+	tw.writer = newPlainWriter(&buf)
+	tw.lastResult = parseTextResult(output)
+	tw.lastResult.Coverage = 70
+	tw.lastResult.HasCoverage = true
+
+	handleSuccessfulTests(tw, output)
+
+	want := "COVERAGE:\n\n" +
+		"example.com/calc/parse   80.0%\n" +
+		"example.com/calc/format  42.5%\n\n" +
+		"ALL TESTS PASSED (0.012s) - total coverage: 70.0%\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseBenchmarks(t *testing.T) {
+	output := "goos: linux\n" +
+		"BenchmarkAdd-8   \t1000000000\t         0.2500 ns/op\t       0 B/op\t       0 allocs/op\n" +
+		"BenchmarkReverse/short-8 \t 5000000\t       240.5 ns/op\n" +
+		"PASS\n"
+
+	benchmarks := parseBenchmarks(output)
+	want := []Benchmark{
+		{Name: "BenchmarkAdd-8", Iterations: 1000000000, NsPerOp: 0.25, Metrics: "0 B/op 0 allocs/op"},
+		{Name: "BenchmarkReverse/short-8", Iterations: 5000000, NsPerOp: 240.5},
+	}
+	if !slices.Equal(benchmarks, want) {
+		t.Errorf("unexpected benchmarks: %+v", benchmarks)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePackageResults(t *testing.T) {
+	output := "=== RUN   TestAdd\n" +
+		"    calc_test.go:12: ok so far\n" +
+		"--- FAIL: TestAdd (0.00s)\n" +
+		"FAIL\n" +
+		"FAIL\texample.com/calc\t0.003s\n" +
+		"ok  \texample.com/calc/parse\t0.012s\tcoverage: 80.0% of statements\n" +
+		"ok  \texample.com/calc/format\t(cached)\n" +
+		"?   \texample.com/calc/cmd\t[no test files]\n" +
+		"FAIL\texample.com/calc/broken [build failed]\n"
+
+	results := parsePackageResults(output)
+	want := []PackageResult{
+		{Package: "example.com/calc", Status: "FAIL", Duration: 3 * time.Millisecond},
+		{Package: "example.com/calc/parse", Status: "ok", Duration: 12 * time.Millisecond, Detail: "coverage: 80.0% of statements", Coverage: 80, HasCoverage: true},
+		{Package: "example.com/calc/format", Status: "ok", Cached: true},
+		{Package: "example.com/calc/cmd", Status: "?", Detail: "[no test files]"},
+		{Package: "example.com/calc/broken", Status: "FAIL", Detail: "[build failed]"},
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("unexpected package results: %+v", results)
+	}
+}
+
+func TestParseAssertions(t *testing.T) {
+	section := `=== RUN   TestAdd
+    calc_test.go:12: Add(1, 2) = 4, want 3
+    calc_test.go:20: unexpected sum:
+        got:  4
+        want: 3
+    helper log without a location
+--- FAIL: TestAdd (0.00s)`
+
+	want := []assertion{
+		{location: "calc_test.go:12", message: "Add(1, 2) = 4, want 3"},
+		{location: "calc_test.go:20", message: "unexpected sum:\ngot:  4\nwant: 3"},
+	}
+	if got := parseAssertions(section); !slices.Equal(got, want) {
+		t.Errorf("parseAssertions() = %q, want %q", got, want)
+	}
+
+	var output strings.Builder
+	tw := &TestWatcher{writer: newPlainWriter(&output)}
+	tw.SetBriefFailures(true)
+	tw.writeFailureSection(section)
+	tw.writer.Flush()
+	wantOutput := "calc_test.go:12: Add(1, 2) = 4, want 3\n" +
+		"calc_test.go:20: unexpected sum:\n" +
+		"    got:  4\n" +
+		"    want: 3\n" +
+		"--- FAIL: TestAdd (0.00s)\n\n"
+	if got := output.String(); got != wantOutput {
+		t.Errorf("brief failure output:\n%s\nwant:\n%s", got, wantOutput)
+	}
+}
+
+func TestSingleFailureCyclesThroughFailures(t *testing.T) {
+	var output strings.Builder
+	tw := &TestWatcher{writer: newPlainWriter(&output)}
+	tw.SetSingleFailure(true)
+
+	tw.writeFailureSections([]string{"--- FAIL: TestAdd (0.00s)", "--- FAIL: TestSub (0.00s)"})
+	tw.writer.Flush()
+	if got, want := output.String(), "--- FAIL: TestAdd (0.00s)\n\nFailure 1 of 2.\n"; got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, want := range []string{"TestSub", "TestAdd"} {
+		output.Reset()
+		tw.showNextFailure()
+		if got := output.String(); !strings.Contains(got, want) {
+			t.Errorf("next failure output:\n%s\nwant it to show %s", got, want)
+		}
+	}
+}
+
+func TestExtractTestSectionsKeepsFailureOfSameName(t *testing.T) {
+	output := `=== RUN   TestA
+    calc_test.go:3: a
+--- FAIL: TestA (0.00s)
+FAIL
+FAIL	example.com/calc	0.001s
+=== RUN   TestA
+--- PASS: TestA (0.00s)
+PASS
+ok  	example.com/calc/two	0.001s
+`
+	sections := extractTestSections(output)
+	if len(sections) != 1 || !strings.Contains(sections[0], "--- FAIL: TestA") {
+		t.Errorf("extractTestSections() = %q, want the failed TestA section", sections)
+	}
+}
+
+func TestExtractTestSectionsGroupsSubtests(t *testing.T) {
+	output := `=== RUN   TestAdd
+=== RUN   TestAdd/zero
+    calc_test.go:16: checking zero
+=== RUN   TestAdd/one
+    calc_test.go:16: checking one
+=== RUN   TestAdd/two
+    calc_test.go:16: checking two
+    calc_test.go:18: 1 + 1 = 2, want 3
+--- FAIL: TestAdd (0.00s)
+    --- PASS: TestAdd/zero (0.00s)
+    --- PASS: TestAdd/one (0.00s)
+    --- FAIL: TestAdd/two (0.00s)
+=== RUN   TestSub
+--- PASS: TestSub (0.00s)
+FAIL
+FAIL	example.com/calc	0.001s
+`
+	sections := extractTestSections(output)
+	if len(sections) != 1 {
+		t.Fatalf("extractTestSections() = %q, want one section for TestAdd", sections)
+	}
+
+	want := `=== RUN   TestAdd
+=== RUN   TestAdd/two
+    calc_test.go:16: checking two
+    calc_test.go:18: 1 + 1 = 2, want 3
+--- FAIL: TestAdd (0.00s)
+    --- FAIL: TestAdd/two (0.00s)`
+	if sections[0] != want {
+		t.Errorf("section:\n%s\nwant:\n%s", sections[0], want)
+	}
+}
@@ -0,0 +1,216 @@
+package watcher
+
+import (
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// rootRun is a test command invocation in one watched root
+type rootRun struct {
+	// dir is the absolute root directory the command runs in
+	dir string
+	// packages are the package patterns to test, relative to dir
+	packages []string
+	// runPattern is the -run pattern of the tests to run in packages, if not the one of the whole run
+	runPattern string
+}
+
+// commonAncestor returns the deepest directory containing all of the absolute dirs
+func commonAncestor(dirs []string) string {
+	ancestor := dirs[0]
+	for _, dir := range dirs[1:] {
+		for !containsPath(ancestor, dir) {
+			parent := filepath.Dir(ancestor)
+			if parent == ancestor {
+				break
+			}
+			ancestor = parent
+		}
+	}
+	return ancestor
+}
+
+// containsPath reports whether path is dir or inside it. Like filepath.Rel, it ignores
+// case differences on Windows, and paths on other volumes are never contained.
+func containsPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && !outsideRel(rel)
+}
+
+// insidePath reports whether path is inside dir, but not dir itself
+func insidePath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && !outsideRel(rel)
+}
+
+// samePath reports whether a and b are the same path, ignoring case on Windows
+func samePath(a, b string) bool {
+	rel, err := filepath.Rel(a, b)
+	return err == nil && rel == "."
+}
+
+// outsideRel reports whether a path returned by filepath.Rel leaves its base directory.
+// Names that merely start with "..", such as "..data", are inside it.
+func outsideRel(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// packagePattern turns a package directory, relative to the directory go test runs in,
+// into the package pattern go test expects, such as "." or "./internal/calc"
+func packagePattern(rel string) string {
+	if filepath.IsAbs(rel) {
+		return filepath.ToSlash(rel)
+	}
+	rel = path.Clean(filepath.ToSlash(rel))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return rel
+	}
+	return "./" + rel
+}
+
+// rootRuns splits package patterns relative to the watch directory into one run per root, with the
+// patterns rewritten relative to the root that contains them. "./..." tests every root, and every
+// module of a root that is a Go workspace.
+func (tw *TestWatcher) rootRuns(packages []string) []rootRun {
+	runs := make([]rootRun, len(tw.roots))
+	for i, root := range tw.roots {
+		runs[i].dir = root
+	}
+
+	for _, pkg := range packages {
+		if pkg == "./..." {
+			for i := range runs {
+				runs[i].packages = append(runs[i].packages, tw.rootPatterns(runs[i].dir)...)
+			}
+			continue
+		}
+
+		dir := filepath.Join(tw.watchDir, filepath.FromSlash(pkg))
+		index := tw.innermostRoot(dir)
+		if index < 0 {
+			continue
+		}
+
+		rel, err := filepath.Rel(tw.roots[index], dir)
+		if err != nil {
+			continue
+		}
+		runs[index].packages = append(runs[index].packages, packagePattern(rel))
+	}
+
+	// Roots without affected packages are not run
+	var selected []rootRun
+	for _, run := range runs {
+		if len(run.packages) > 0 {
+			selected = append(selected, run)
+		}
+	}
+	return selected
+}
+
+// innermostRoot returns the index of the root containing dir, the innermost one as roots may be nested,
+// or -1 if no root contains it
+func (tw *TestWatcher) innermostRoot(dir string) int {
+	index := -1
+	for i, root := range tw.roots {
+		if containsPath(root, dir) && (index < 0 || len(root) > len(tw.roots[index])) {
+			index = i
+		}
+	}
+	return index
+}
+
+// packageRuns returns the runs that test package patterns relative to the watch directory,
+// one for each root containing some, split into batches
+func (tw *TestWatcher) packageRuns(packages []string) []rootRun {
+	runs := tw.rootRuns(packages)
+	if tw.batchSize > 0 {
+		runs = tw.expandRecursivePatterns(runs)
+	}
+	return batchRuns(runs, tw.batchSize)
+}
+
+// expandRecursivePatterns replaces the "/..." patterns of runs, such as "./...", with the listed packages
+// they match, so runs of every package can be split into batches too. Patterns that match no listed
+// package are left as is.
+func (tw *TestWatcher) expandRecursivePatterns(runs []rootRun) []rootRun {
+	if !slices.ContainsFunc(runs, func(run rootRun) bool {
+		return slices.ContainsFunc(run.packages, isRecursivePattern)
+	}) {
+		return runs
+	}
+	dirs, err := tw.listedPackageDirs()
+	if err != nil {
+		tw.logger.Warn("could not list packages to split into batches", "err", err)
+		return runs
+	}
+
+	expanded := make([]rootRun, 0, len(runs))
+	for _, run := range runs {
+		var packages []string
+		for _, pattern := range run.packages {
+			if !isRecursivePattern(pattern) {
+				packages = append(packages, pattern)
+				continue
+			}
+
+			prefix := strings.TrimSuffix(pattern, "/...")
+			var matched []string
+			for importPath, dir := range dirs {
+				dir = filepath.Join(tw.watchDir, filepath.FromSlash(dir))
+				// Like go test, a pattern doesn't reach into a nested root, which is another module
+				index := tw.innermostRoot(dir)
+				if index < 0 || tw.roots[index] != run.dir || !matchesRecursivePattern(run.dir, prefix, importPath, dir) {
+					continue
+				}
+				if rel, err := filepath.Rel(run.dir, dir); err == nil {
+					matched = append(matched, packagePattern(rel))
+				}
+			}
+			if len(matched) == 0 {
+				matched = []string{pattern}
+			}
+			slices.Sort(matched)
+			packages = append(packages, matched...)
+		}
+		expanded = append(expanded, rootRun{dir: run.dir, packages: packages, runPattern: run.runPattern})
+	}
+	return expanded
+}
+
+// matchesRecursivePattern reports whether the package with importPath in dir is matched by the pattern
+// prefix + "/...", where prefix is either a directory relative to root, such as ".", or an import path,
+// such as the module path of a workspace module
+func matchesRecursivePattern(root, prefix, importPath, dir string) bool {
+	if prefix == "." || strings.HasPrefix(prefix, "./") || strings.HasPrefix(prefix, "../") || filepath.IsAbs(prefix) {
+		return containsPath(filepath.Join(root, filepath.FromSlash(prefix)), dir)
+	}
+	return importPath == prefix || strings.HasPrefix(importPath, prefix+"/")
+}
+
+// isRecursivePattern reports whether a package pattern, such as "./...", matches a directory and the packages below it
+func isRecursivePattern(pattern string) bool {
+	return strings.HasSuffix(pattern, "/...")
+}
+
+// batchRuns splits runs so none tests more than size packages, to be run one after another (0 disables).
+// "./..." is left as is when its packages couldn't be listed.
+func batchRuns(runs []rootRun, size int) []rootRun {
+	if size <= 0 {
+		return runs
+	}
+
+	var batches []rootRun
+	for _, run := range runs {
+		if slices.Contains(run.packages, "./...") {
+			batches = append(batches, run)
+			continue
+		}
+		for batch := range slices.Chunk(run.packages, size) {
+			batches = append(batches, rootRun{dir: run.dir, packages: batch, runPattern: run.runPattern})
+		}
+	}
+	return batches
+}
@@ -0,0 +1,295 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestRootRuns(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+	svcA := filepath.Join(base, "svc-a")
+	svcB := filepath.Join(base, "svc-b")
+	tw := &TestWatcher{watchDir: commonAncestor([]string{svcA, svcB}), roots: []string{svcA, svcB}}
+
+	if tw.watchDir != base {
+		t.Fatalf("watchDir = %q, want %q", tw.watchDir, base)
+	}
+
+	got := tw.rootRuns([]string{"./svc-a", "./svc-b/internal/store", "./svc-a/api"})
+	want := []rootRun{
+		{dir: svcA, packages: []string{".", "./api"}},
+		{dir: svcB, packages: []string{"./internal/store"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rootRuns() = %+v, want %+v", got, want)
+	}
+
+	got = tw.rootRuns([]string{"./..."})
+	want = []rootRun{
+		{dir: svcA, packages: []string{"./..."}},
+		{dir: svcB, packages: []string{"./..."}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rootRuns(./...) = %+v, want %+v", got, want)
+	}
+}
+
+func TestBatchRuns(t *testing.T) {
+	runs := []rootRun{
+		{dir: "/repo/svc-a", packages: []string{".", "./api", "./store", "./web", "./cmd"}},
+		{dir: "/repo/svc-b", packages: []string{"./..."}},
+	}
+
+	got := batchRuns(runs, 2)
+	want := []rootRun{
+		{dir: "/repo/svc-a", packages: []string{".", "./api"}},
+		{dir: "/repo/svc-a", packages: []string{"./store", "./web"}},
+		{dir: "/repo/svc-a", packages: []string{"./cmd"}},
+		{dir: "/repo/svc-b", packages: []string{"./..."}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("batchRuns() = %+v, want %+v", got, want)
+	}
+
+	if got := batchRuns(runs, 0); !reflect.DeepEqual(got, runs) {
+		t.Errorf("batchRuns(0) = %+v, want %+v", got, runs)
+	}
+}
+
+func TestPackageRunsBatchesAllPackages(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+	svcA := filepath.Join(base, "svc-a")
+	nested := filepath.Join(svcA, "tools")
+	tw := &TestWatcher{
+		watchDir:  base,
+		roots:     []string{svcA, nested},
+		batchSize: 2,
+		packageDirs: map[string]string{
+			"example.com/a":         "svc-a",
+			"example.com/a/api":     "svc-a/api",
+			"example.com/a/store":   "svc-a/store",
+			"example.com/tools":     "svc-a/tools",
+			"example.com/tools/gen": "svc-a/tools/gen",
+		},
+		logger: slog.New(slog.DiscardHandler),
+	}
+
+	// The packages of every root are listed, so a run of everything is split into batches too,
+	// and the nested root is tested on its own like go test ./... does
+	got := tw.packageRuns([]string{"./..."})
+	want := []rootRun{
+		{dir: svcA, packages: []string{".", "./api"}},
+		{dir: svcA, packages: []string{"./store"}},
+		{dir: nested, packages: []string{".", "./gen"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packageRuns(./...) = %+v, want %+v", got, want)
+	}
+
+	// Without batches, go test lists the packages itself
+	tw.batchSize = 0
+	want = []rootRun{
+		{dir: svcA, packages: []string{"./..."}},
+		{dir: nested, packages: []string{"./..."}},
+	}
+	if got := tw.packageRuns([]string{"./..."}); !reflect.DeepEqual(got, want) {
+		t.Errorf("packageRuns(./...) without batches = %+v, want %+v", got, want)
+	}
+}
+
+func TestWorkspaceRootRuns(t *testing.T) {
+	t.Setenv("GOWORK", "")
+	dir := t.TempDir()
+	for _, module := range []string{"api", "calc", "tools"} {
+		if err := os.Mkdir(filepath.Join(dir, module), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, module, "go.mod"), "module example.com/"+module+" // comment\n\ngo 1.24\n")
+	}
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.24\n\nuse (\n\t./api\n\t\"./calc\" // quoted\n)\n\nuse ./tools\n")
+
+	tw := &TestWatcher{watchDir: dir, roots: []string{dir}}
+	got := tw.rootRuns([]string{"./...", "./api/handler"})
+	want := []rootRun{{dir: dir, packages: []string{"example.com/api/...", "example.com/calc/...", "example.com/tools/...", "./api/handler"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rootRuns() = %+v, want %+v", got, want)
+	}
+
+	// Batches of a workspace take the packages of each module, matched by module path
+	tw.batchSize = 3
+	tw.logger = slog.New(slog.DiscardHandler)
+	tw.packageDirs = map[string]string{
+		"example.com/api":         "api",
+		"example.com/api/handler": "api/handler",
+		"example.com/calc":        "calc",
+		"example.com/tools":       "tools",
+	}
+	got = tw.packageRuns([]string{"./..."})
+	want = []rootRun{
+		{dir: dir, packages: []string{"./api", "./api/handler", "./calc"}},
+		{dir: dir, packages: []string{"./tools"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packageRuns() = %+v, want %+v", got, want)
+	}
+	tw.batchSize = 0
+
+	// Workspace mode can be turned off like for the go command
+	t.Setenv("GOWORK", "off")
+	want = []rootRun{{dir: dir, packages: []string{"./..."}}}
+	if got := tw.rootRuns([]string{"./..."}); !reflect.DeepEqual(got, want) {
+		t.Errorf("rootRuns() with GOWORK=off = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseWorkspaceUses(t *testing.T) {
+	tests := []struct {
+		name   string
+		gowork string
+		want   []string
+	}{
+		{
+			name:   "block",
+			gowork: "go 1.24\n\nuse (\n\t./api\n\t./calc\n)\n",
+			want:   []string{"./api", "./calc"},
+		},
+		{
+			name:   "single lines",
+			gowork: "go 1.24\n\nuse ./api\nuse\t../shared\n",
+			want:   []string{"./api", "../shared"},
+		},
+		{
+			name:   "block and single line",
+			gowork: "use (\n\t./api\n)\nuse ./tools\n",
+			want:   []string{"./api", "./tools"},
+		},
+		{
+			name:   "comments",
+			gowork: "// use ./old\nuse ( // modules\n\t./api // the API\n\t// ./calc\n\n)\nuse ./tools // tooling\n",
+			want:   []string{"./api", "./tools"},
+		},
+		{
+			name:   "quoted paths",
+			gowork: "use (\n\t\"./my api\"\n\t`./calc`\n)\nuse \"./tools\"\n",
+			want:   []string{"./my api", "./calc", "./tools"},
+		},
+		{
+			name:   "other directives",
+			gowork: "go 1.24\n\ntoolchain go1.24.2\n\nuser ./api\n\nreplace example.com/a => ./a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseWorkspaceUses([]byte(tt.gowork)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWorkspaceUses() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAffectedPackages(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	tw := &TestWatcher{
+		watchDir: root,
+		packageDependencies: map[string][]string{
+			"internal/calc/add": {".", "cmd/calc"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want []string
+	}{
+		{
+			name: "root package",
+			file: filepath.Join(root, "main.go"),
+			want: []string{"."},
+		},
+		{
+			name: "one level",
+			file: filepath.Join(root, "calc", "calc.go"),
+			want: []string{"./calc"},
+		},
+		{
+			name: "multiple levels with dependents",
+			file: filepath.Join(root, "internal", "calc", "add", "add.go"),
+			want: []string{"./internal/calc/add", ".", "./cmd/calc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tw.FindAffectedPackages(tt.file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAffectedPackages(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackagesToTest(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	tw := &TestWatcher{
+		watchDir:     root,
+		changedFiles: map[string]bool{filepath.Join(root, "main.go"): true, filepath.Join(root, "internal", "calc", "add.go"): true},
+		failedTests:  map[string]bool{"calc/TestAdd": true},
+		logger:       slog.New(slog.DiscardHandler),
+	}
+
+	want := []string{".", "./calc", "./internal/calc"}
+	if got := tw.packagesToTest(); !reflect.DeepEqual(got, want) {
+		t.Errorf("packagesToTest() = %q, want %q", got, want)
+	}
+}
+
+func TestPathHandling(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	tw := &TestWatcher{watchDir: root}
+	tw.SetExcludePatterns([]string{filepath.FromSlash("testdata/*"), "*.pb.go"})
+
+	tests := []struct {
+		path     string
+		contains bool
+		inside   bool
+		excluded bool
+	}{
+		{path: "/repo", contains: true},
+		{path: "/repo/calc", contains: true, inside: true},
+		{path: "/repo/internal/calc/add.go", contains: true, inside: true},
+		{path: "/repo/..data/fixture.go", contains: true, inside: true},
+		{path: "/repo/calc/testdata/fixture.go", contains: true, inside: true, excluded: true},
+		{path: "/repo/api/api.pb.go", contains: true, inside: true, excluded: true},
+		{path: "/repo2/calc", contains: false},
+		{path: "/calc", contains: false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.FromSlash(tt.path)
+		t.Run(tt.path, func(t *testing.T) {
+			if got := containsPath(root, path); got != tt.contains {
+				t.Errorf("containsPath(%q) = %v, want %v", path, got, tt.contains)
+			}
+			if got := insidePath(root, path); got != tt.inside {
+				t.Errorf("insidePath(%q) = %v, want %v", path, got, tt.inside)
+			}
+			if got := tw.isExcluded(path); got != tt.excluded {
+				t.Errorf("isExcluded(%q) = %v, want %v", path, got, tt.excluded)
+			}
+		})
+	}
+
+	if runtime.GOOS == "windows" {
+		// Paths differing only in case are the same directory on Windows
+		if !insidePath(`C:\Repo`, `c:\repo\calc`) {
+			t.Errorf(`insidePath(C:\Repo, c:\repo\calc) = false, want true`)
+		}
+		if got := (&TestWatcher{watchDir: `C:\Repo`}).FindAffectedPackages(`c:\repo\calc\add.go`); !reflect.DeepEqual(got, []string{"./calc"}) {
+			t.Errorf("FindAffectedPackages() = %q, want [./calc]", got)
+		}
+	}
+}
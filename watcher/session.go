@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultSlowestTests is how many tests the s key lists unless SetSlowestTests is called
+const defaultSlowestTests = 10
+
+// SessionStats summarizes the test runs of a watch session
+type SessionStats struct {
+	// Duration is the time since watching started
+	Duration time.Duration
+	// Runs counts the completed runs, of which Passed passed and Failed did not
+	Runs   int
+	Passed int
+	Failed int
+	// TestTime is the wall-clock time spent running tests, including runs cancelled by newer changes
+	TestTime time.Duration
+	// TestFailures counts the runs each test failed in, keyed by "importpath/TestName"
+	TestFailures map[string]int
+	// TestDurations holds the longest time each top-level test took in a run, keyed by "importpath/TestName"
+	TestDurations map[string]time.Duration
+}
+
+// TestDuration is the time a test took
+type TestDuration struct {
+	Test     string
+	Duration time.Duration
+}
+
+// SessionStats returns the statistics of the test runs since watching started
+func (tw *TestWatcher) SessionStats() SessionStats {
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+
+	stats := tw.session
+	stats.TestFailures = maps.Clone(tw.session.TestFailures)
+	stats.TestDurations = maps.Clone(tw.session.TestDurations)
+	if !tw.sessionStart.IsZero() {
+		stats.Duration = time.Since(tw.sessionStart)
+	}
+	return stats
+}
+
+// recordSessionRun counts a completed run in the session statistics.
+// It must be called with historyMutex held.
+func (tw *TestWatcher) recordSessionRun(result Result) {
+	tw.session.Runs++
+	if result.Outcome == OutcomePassed {
+		tw.session.Passed++
+	} else {
+		tw.session.Failed++
+	}
+
+	for _, test := range result.FailedTests {
+		if tw.session.TestFailures == nil {
+			tw.session.TestFailures = make(map[string]int)
+		}
+		tw.session.TestFailures[test]++
+	}
+
+	for test, duration := range result.TestDurations {
+		if tw.session.TestDurations == nil {
+			tw.session.TestDurations = make(map[string]time.Duration)
+		}
+		tw.session.TestDurations[test] = max(tw.session.TestDurations[test], duration)
+	}
+}
+
+// recordTestTime adds the wall-clock time of a test run to the session statistics
+func (tw *TestWatcher) recordTestTime(elapsed time.Duration) {
+	tw.historyMutex.Lock()
+	defer tw.historyMutex.Unlock()
+	tw.session.TestTime += elapsed
+}
+
+// MostFailedTest returns the test that failed in the most runs and in how many, with ties going
+// to the first name in sorted order. ok is false when no test failed.
+func (s SessionStats) MostFailedTest() (test string, failures int, ok bool) {
+	for name, count := range s.TestFailures {
+		if count > failures || count == failures && name < test {
+			test, failures = name, count
+		}
+	}
+	return test, failures, failures > 0
+}
+
+// SlowestTests returns the n tests that took longest, slowest first, with ties in name order
+func (s SessionStats) SlowestTests(n int) []TestDuration {
+	tests := make([]TestDuration, 0, len(s.TestDurations))
+	for test, duration := range s.TestDurations {
+		tests = append(tests, TestDuration{Test: test, Duration: duration})
+	}
+	slices.SortFunc(tests, func(a, b TestDuration) int {
+		if c := cmp.Compare(b.Duration, a.Duration); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Test, b.Test)
+	})
+	return tests[:min(n, len(tests))]
+}
+
+// SetSlowestTests sets how many of the slowest tests of the session the summary lists when the
+// watcher is stopped (0 disables). The s key lists them at any time.
+func (tw *TestWatcher) SetSlowestTests(n int) {
+	tw.slowestTests = n
+}
+
+// slowestTestsReport lists the n slowest tests of the session as an aligned table
+func (tw *TestWatcher) slowestTestsReport(n int) string {
+	tests := tw.SessionStats().SlowestTests(n)
+	if len(tests) == 0 {
+		return "No test durations recorded yet.\n"
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "SLOWEST TESTS:\n\n")
+	table := tabwriter.NewWriter(&report, 0, 0, 2, ' ', 0)
+	for _, test := range tests {
+		fmt.Fprintf(table, "%s\t%s\n", test.Duration.Round(time.Millisecond), test.Test)
+	}
+	table.Flush()
+	return report.String()
+}
+
+// showSlowestTests writes the slowest tests of the session, for the s key
+func (tw *TestWatcher) showSlowestTests() {
+	n := tw.slowestTests
+	if n <= 0 {
+		n = defaultSlowestTests
+	}
+	fmt.Fprint(tw.writer, tw.slowestTestsReport(n))
+	tw.writer.Flush()
+}
+
+// sessionSummary describes the session, for when the watcher is stopped
+func (tw *TestWatcher) sessionSummary() string {
+	stats := tw.SessionStats()
+
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "Session summary: %d runs in %s, %d passed, %d failed, %s spent testing\n",
+		stats.Runs, stats.Duration.Round(time.Second), stats.Passed, stats.Failed, stats.TestTime.Round(100*time.Millisecond))
+	if test, failures, ok := stats.MostFailedTest(); ok {
+		fmt.Fprintf(&summary, "Most failing test: %s (failed in %d runs)\n", test, failures)
+	}
+	if tw.slowestTests > 0 && len(stats.TestDurations) > 0 {
+		summary.WriteString(tw.slowestTestsReport(tw.slowestTests))
+	}
+	return summary.String()
+}
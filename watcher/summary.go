@@ -0,0 +1,44 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SetSummaryFile makes the watcher write a one-line summary of the latest run to path after every run,
+// e.g. for a shell prompt showing whether the tests pass. An empty path disables the file.
+func (tw *TestWatcher) SetSummaryFile(path string) {
+	tw.summaryFile = path
+}
+
+// summaryLine describes a completed run as its outcome followed by the test counts and completion time,
+// e.g. "FAIL passed=12 failed=1 skipped=0 time=2024-05-01T10:00:00Z"
+func summaryLine(result Result, at time.Time) string {
+	return fmt.Sprintf("%s passed=%d failed=%d skipped=%d time=%s\n",
+		result.Outcome, result.Passed, result.Failed, result.Skipped, at.UTC().Format(time.RFC3339))
+}
+
+// writeSummaryFile replaces the summary file with the summary of result. The summary is written to a
+// temporary file that is then renamed over it, so readers never see a half-written file.
+func (tw *TestWatcher) writeSummaryFile(result Result) error {
+	// The temporary file is created next to the summary file, as a rename can't cross filesystems
+	tmp, err := os.CreateTemp(filepath.Dir(tw.summaryFile), filepath.Base(tw.summaryFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create summary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(summaryLine(result, time.Now())); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write summary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write summary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), tw.summaryFile); err != nil {
+		return fmt.Errorf("failed to replace summary file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package watcher
+
+import "errors"
+
+// enableKeyPresses is not supported on this platform
+func enableKeyPresses(fd int) (func(), error) {
+	return nil, errors.New("keyboard controls are not supported on this platform")
+}
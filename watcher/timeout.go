@@ -0,0 +1,105 @@
+package watcher
+
+import "strings"
+
+const timeoutMarker = "panic: test timed out after "
+
+// timeoutReport describes a test binary that was aborted by the go test timeout
+type timeoutReport struct {
+	after  string
+	tests  []string
+	stacks []string
+}
+
+// parseTimeout detects a go test timeout panic and attributes it to the tests that were running
+func parseTimeout(output string) (timeoutReport, bool) {
+	start := strings.Index(output, timeoutMarker)
+	if start < 0 {
+		return timeoutReport{}, false
+	}
+
+	report := timeoutReport{}
+	panicOutput := output[start:]
+	lines := strings.Split(panicOutput, "\n")
+	report.after = strings.TrimSpace(strings.TrimPrefix(lines[0], timeoutMarker))
+
+	// Go 1.20+ lists the tests that were still running below the panic line
+	inRunningTests := false
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "running tests:" {
+			inRunningTests = true
+			continue
+		}
+		if !inRunningTests {
+			continue
+		}
+		if trimmed == "" {
+			break
+		}
+		report.tests = append(report.tests, strings.Fields(trimmed)[0])
+	}
+
+	if len(report.tests) == 0 {
+		if test := lastUnfinishedTest(output[:start]); test != "" {
+			report.tests = append(report.tests, test)
+		}
+	}
+
+	report.stacks = offendingStacks(panicOutput, report.tests)
+	return report, true
+}
+
+// lastUnfinishedTest returns the last test that was started but did not report a result
+func lastUnfinishedTest(output string) string {
+	finished := make(map[string]bool)
+	var started []string
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "===" && fields[1] == "RUN":
+			started = append(started, fields[2])
+		case fields[0] == "---":
+			finished[fields[2]] = true
+		}
+	}
+
+	for i := len(started) - 1; i >= 0; i-- {
+		if !finished[started[i]] {
+			return started[i]
+		}
+	}
+	return ""
+}
+
+// offendingStacks returns the goroutine stacks that run one of the given tests,
+// or every goroutine stack when none of them can be attributed
+func offendingStacks(panicOutput string, tests []string) []string {
+	var all, offending []string
+
+	for _, block := range strings.Split(panicOutput, "\n\n") {
+		block = strings.TrimSpace(block)
+		if !strings.HasPrefix(block, "goroutine ") {
+			continue
+		}
+		all = append(all, block)
+
+		for _, test := range tests {
+			// Subtests run in a closure of their top-level test function
+			function := "." + strings.Split(test, "/")[0]
+			if strings.Contains(block, function+"(") || strings.Contains(block, function+".func") {
+				offending = append(offending, block)
+				break
+			}
+		}
+	}
+
+	if len(offending) == 0 {
+		return all
+	}
+	return offending
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const timeoutOutput = `=== RUN   TestFast
+--- PASS: TestFast (0.00s)
+=== RUN   TestSlow
+panic: test timed out after 1s
+	running tests:
+		TestSlow (1s)
+
+goroutine 18 [running]:
+testing.(*M).startAlarm.func1()
+	/usr/local/go/src/testing/testing.go:2366 +0x385
+created by time.goFunc
+	/usr/local/go/src/time/sleep.go:177 +0x2d
+
+goroutine 1 [chan receive]:
+testing.(*T).Run(0xc000007860, {0x5254af?, 0x4b7c4e?}, 0x52d138)
+	/usr/local/go/src/testing/testing.go:1750 +0x3ab
+main.main()
+	_testmain.go:47 +0x195
+
+goroutine 6 [sleep]:
+time.Sleep(0x12a05f200)
+	/usr/local/go/src/runtime/time.go:195 +0x125
+example.com/calc.TestSlow(0x0?)
+	/tmp/calc/calc_test.go:9 +0x1d
+testing.tRunner(0xc0000079c0, 0x52d130)
+	/usr/local/go/src/testing/testing.go:1690 +0xf4
+exit status 2
+FAIL	example.com/calc	1.012s
+`
+
+func TestParseTimeout(t *testing.T) {
+	report, ok := parseTimeout(timeoutOutput)
+	if !ok {
+		t.Fatal("timeout was not detected")
+	}
+
+	if report.after != "1s" {
+		t.Errorf("unexpected timeout duration: %q", report.after)
+	}
+	if !slices.Equal(report.tests, []string{"TestSlow"}) {
+		t.Errorf("unexpected timed out tests: %v", report.tests)
+	}
+	if len(report.stacks) != 1 || !strings.Contains(report.stacks[0], "calc.TestSlow(") {
+		t.Errorf("expected only the stack running TestSlow, got %v", report.stacks)
+	}
+}
+
+func TestParseTimeoutWithoutRunningTests(t *testing.T) {
+	output := strings.Replace(timeoutOutput, "\trunning tests:\n\t\tTestSlow (1s)\n", "", 1)
+
+	report, ok := parseTimeout(output)
+	if !ok {
+		t.Fatal("timeout was not detected")
+	}
+	if !slices.Equal(report.tests, []string{"TestSlow"}) {
+		t.Errorf("expected the unfinished test to be blamed, got %v", report.tests)
+	}
+}
+
+func TestParseTextResultCountsTimeouts(t *testing.T) {
+	result := parseTextResult(timeoutOutput)
+
+	if result.TimedOut != 1 {
+		t.Errorf("expected 1 timed out test, got %d", result.TimedOut)
+	}
+	if result.Failed != 0 || result.Passed != 1 {
+		t.Errorf("unexpected counts: passed=%d failed=%d", result.Passed, result.Failed)
+	}
+}
+
+func TestParseTimeoutNotPresent(t *testing.T) {
+	if _, ok := parseTimeout("--- FAIL: TestSub (0.00s)\nFAIL\n"); ok {
+		t.Error("timeout detected in ordinary failure output")
+	}
+}
@@ -1,320 +1,1674 @@
 package watcher
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bond-kaneko/go-test-watcher/filenotify"
+	"github.com/bond-kaneko/go-test-watcher/notify"
 	"github.com/fsnotify/fsnotify"
-	"github.com/gosuri/uilive"
+)
+
+// Build failures are retried once, shortly after, unless SetBuildRetries is called
+const (
+	DefaultBuildRetries    = 1
+	DefaultBuildRetryDelay = 250 * time.Millisecond
 )
 
 // TestWatcher watches for file changes and runs tests
 type TestWatcher struct {
-	watchDir            string
-	debounceDelay       time.Duration
-	fileFilter          func(string) bool
-	watcher             filenotify.FileWatcher
-	withCoverage        bool
-	writer              *uilive.Writer
+	watchDir           string
+	roots              []string
+	debounceDelay      time.Duration
+	perPackageDebounce bool
+	buildRetries       int
+	buildRetryDelay    time.Duration
+	fileFilter         func(string) bool
+	watcher            filenotify.FileWatcher
+	watcherFallback    *filenotify.FallbackError
+	goBinary           string
+	testCommand        string
+	dockerImage        string
+	env                map[string]string
+	testArgs           []string
+	runPattern         string
+	buildTags          []string
+	extraArgs          []string
+	benchPattern       string
+	failedFirst        bool
+	verbose            bool
+	withRace           bool
+	disableCache       bool
+	withJSON           bool
+	withCoverage       bool
+	withTotalCoverage  bool
+	coverPackages      []string
+	coverageThreshold  float64
+	dryWatch           bool
+	dryRun             bool
+	clearScreen        bool
+	color              bool
+	briefFailures      bool
+	singleFailure      bool
+	gitChanged         bool
+	slowestTests       int
+	failureSections    []string
+	failureIndex       int
+	summaryFile        string
+	jsonOutput         io.Writer
+	notifications      bool
+	bellMode           BellMode
+	webhookURL         string
+	keyboardControls   bool
+	restoreTerminal    func()
+	runAll             bool
+	alwaysRunAll       bool
+	skipInitialRun     bool
+	fullRunEvery       int
+	parallelPackages   int
+	batchSize          int
+	flakyRetries       int
+	flakyCounts        map[string]int
+	preRunHook         func() error
+	preRunPatterns     []string
+	onSuccess          string
+	onFailure          string
+	hooks              sync.WaitGroup
+	// historyMutex guards history, session, sessionStart and flakyCounts, which are read from other goroutines
+	historyMutex    sync.Mutex
+	history         []RunRecord
+	session         SessionStats
+	sessionStart    time.Time
+	incrementalRuns int
+	previousOutcome Outcome
+	ignoreGenerated bool
+	formatOnSave    bool
+	formatMutex     sync.Mutex
+	formattedHashes map[string]string
+	generatedHashes map[string]string
+	withLint        bool
+	lintCommand     string
+	lintArgs        []string
+	// lintMissingReported is set once a missing linter was reported, and is guarded by runMutex like the runs
+	lintMissingReported bool
+	watchEmbeds         bool
+	embedPatterns       map[string][]string
+	gitignoreRules      map[string][]ignoreRule
+	ignoreRules         []ignoreRule
+	excludePatterns     []string
+	killGracePeriod     time.Duration
+	timeout             time.Duration
+	processMutex        sync.Mutex
+	cancelRun           context.CancelFunc
+	runningDone         chan struct{}
+	runMutex            sync.Mutex
+	closed              bool
+	coverProfile        string
+	writer              outputWriter
+	plainOutput         bool
+	logger              *slog.Logger
+	writerStarted       bool
+	// stateMutex guards changedFiles, newTestFiles and failedTests, which the event loop, the debounce
+	// timer and test runs use from different goroutines. The rest of what is learned from file events
+	// is guarded by collectMutex.
+	stateMutex          sync.Mutex
 	changedFiles        map[string]bool
 	failedTests         map[string]bool
 	lastChangedFile     string
+	lastResult          Result
 	packageDependencies map[string][]string
+	packageDirs         map[string]string
+	fileImports         map[string]string
+	watchedDirs         map[string]bool
+	followSymlinks      bool
+	testDirsOnly        bool
+	includeVendor       bool
+	focusDir            string
+	triggerOps          fsnotify.Op
+	newTestFiles        map[string]bool
+	newTestFileOnly     bool
+	dependenciesStale   bool
+	modulesChanged      bool
+	pending             pendingEvents
+	dedup               eventDeduper
+	// collectMutex guards watchedDirs, gitignoreRules, fileImports, embedPatterns, generatedHashes,
+	// packageDependencies, packageDirs, dependenciesStale and modulesChanged. Collecting changes updates
+	// them while test runs read them. They are set up on startup, before either happens.
+	collectMutex    sync.Mutex
+	minInterval     time.Duration
+	rateMutex       sync.Mutex
+	lastRunEnd      time.Time
+	runInProgress   bool
+	runStart        time.Time
+	runAfterCurrent bool
+	delayedRun      *time.Timer
+	paused          bool
 }
 
 // NewTestWatcher creates a new test watcher for the specified directory
 func NewTestWatcher(watchDir string) (*TestWatcher, error) {
-	if watchDir == "" {
-		var err error
-		watchDir, err = os.Getwd()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get current directory: %w", err)
-		}
-	}
+	return NewTestWatcherWithRoots([]string{watchDir})
+}
 
-	watcher, err := filenotify.New()
+// NewTestWatcherWithRoots creates a new test watcher for several root directories, such as the
+// modules of a monorepo. Tests run separately in each root, so every root should be a Go module
+// or lie inside one. An empty root is the current directory.
+func NewTestWatcherWithRoots(roots []string) (*TestWatcher, error) {
+	absRoots, err := resolveRoots(roots)
 	if err != nil {
+		return nil, err
+	}
+
+	// Package paths are tracked relative to the directory containing all roots
+	watchDir := commonAncestor(absRoots)
+
+	watcher, err := filenotify.NewForPath(watchDir, filenotify.DefaultPollFilesystems)
+	var fallback *filenotify.FallbackError
+	if err != nil && !errors.As(err, &fallback) {
 		return nil, fmt.Errorf("failed to initialize watcher: %w", err)
 	}
 
-	writer := uilive.New()
-	writer.RefreshInterval = time.Millisecond * 100
+	tw := newTestWatcher(watchDir, absRoots, watcher)
+	tw.watcherFallback = fallback
+	return tw, nil
+}
+
+// NewTestWatcherWithWatcher creates a new test watcher for a directory that receives its file events
+// from fw instead of the filesystem, e.g. to feed synthetic events to Watch in tests.
+// The test watcher takes ownership of fw and closes it when it is closed.
+func NewTestWatcherWithWatcher(watchDir string, fw filenotify.FileWatcher) (*TestWatcher, error) {
+	absRoots, err := resolveRoots([]string{watchDir})
+	if err != nil {
+		return nil, err
+	}
+	return newTestWatcher(absRoots[0], absRoots, fw), nil
+}
+
+// resolveRoots returns the absolute paths of roots, an empty root being the current directory
+func resolveRoots(roots []string) ([]string, error) {
+	if len(roots) == 0 {
+		roots = []string{""}
+	}
 
+	absRoots := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if root == "" {
+			var err error
+			root, err = os.Getwd()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get current directory: %w", err)
+			}
+		}
+
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve watch directory: %w", err)
+		}
+		absRoots = append(absRoots, absRoot)
+	}
+	return absRoots, nil
+}
+
+// newTestWatcher creates a test watcher with the default settings
+func newTestWatcher(watchDir string, roots []string, watcher filenotify.FileWatcher) *TestWatcher {
 	return &TestWatcher{
-		watchDir:      watchDir,
-		debounceDelay: 500 * time.Millisecond,
+		watchDir:        watchDir,
+		roots:           roots,
+		debounceDelay:   500 * time.Millisecond,
+		buildRetries:    DefaultBuildRetries,
+		buildRetryDelay: DefaultBuildRetryDelay,
+		triggerOps:      DefaultTriggerOps,
+		bellMode:        DefaultBellMode,
 		fileFilter: func(path string) bool {
 			return filepath.Ext(path) == ".go"
 		},
 		watcher:             watcher,
+		goBinary:            "go",
+		testCommand:         "go",
+		testArgs:            []string{"test"},
+		verbose:             true,
 		withCoverage:        false,
-		writer:              writer,
+		writer:              newLiveWriter(),
+		logger:              newDefaultLogger(),
 		changedFiles:        make(map[string]bool),
+		newTestFiles:        make(map[string]bool),
 		failedTests:         make(map[string]bool),
 		packageDependencies: make(map[string][]string),
-	}, nil
+		packageDirs:         make(map[string]string),
+		fileImports:         make(map[string]string),
+		watchedDirs:         make(map[string]bool),
+		gitignoreRules:      make(map[string][]ignoreRule),
+		flakyCounts:         make(map[string]int),
+		generatedHashes:     make(map[string]string),
+		formattedHashes:     make(map[string]string),
+		embedPatterns:       make(map[string][]string),
+		killGracePeriod:     5 * time.Second,
+		lintCommand:         "golangci-lint",
+		lintArgs:            []string{"run", "--out-format=json"},
+	}
 }
 
 // Watch starts watching for file changes and running tests
 func (tw *TestWatcher) Watch() error {
+	return tw.WatchContext(context.Background())
+}
+
+// WatchContext is like Watch, but shuts down cleanly when ctx is done.
+// Pending runs are cancelled, an in-flight test process is terminated and
+// awaited, and the terminal output and file watcher are closed before returning.
+func (tw *TestWatcher) WatchContext(ctx context.Context) error {
+	// Tell why changes are polled for, e.g. when a large repository hits the inotify limits or is on NFS
+	if tw.watcherFallback != nil {
+		tw.logger.Warn(tw.watcherFallback.Error())
+	}
+
+	tw.historyMutex.Lock()
+	tw.sessionStart = time.Now()
+	tw.historyMutex.Unlock()
+
 	// Add directories to watch (non-recursive)
-	if err := filepath.Walk(tw.watchDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	clear(tw.gitignoreRules)
+	if tw.focusDir != "" {
+		// The dependency graph is not needed, as only the focused package is tested. The packages
+		// of failed tests are listed when they fail, to re-run them.
+		if err := tw.addFocusWatch(); err != nil {
+			return fmt.Errorf("error setting up directory watch: %w", err)
 		}
-		// Skip hidden directories
-		if info.IsDir() {
-			if strings.HasPrefix(info.Name(), ".") {
-				return filepath.SkipDir
+	} else {
+		if err := tw.addRootWatches(); err != nil {
+			return fmt.Errorf("error setting up directory watch: %w", err)
+		}
+
+		// The dependency graph is not needed either when every run tests all packages
+		if !tw.alwaysRunAll {
+			if err := tw.RefreshDependencies(); err != nil {
+				fmt.Printf("Could not build package dependency graph, only changed packages will be tested: %v\n", err)
 			}
-			return tw.watcher.Add(path)
 		}
-		return nil
-	}); err != nil {
-		return fmt.Errorf("error setting up directory watch: %w", err)
 	}
 
-	fmt.Println("Watching for file changes. Press Ctrl+C to exit.")
+	if tw.dryWatch {
+		fmt.Println("Watching for file changes. Press Ctrl+C to exit.")
+		return tw.printEvents(ctx)
+	}
+
+	var keys <-chan byte
+	if tw.keyboardControls {
+		var restore func()
+		keys, restore = tw.readKeys()
+		defer restore()
+	}
+	if keys != nil {
+		fmt.Printf("Watching for file changes. %s\n", keyHelp)
+	} else {
+		fmt.Println("Watching for file changes. Press Ctrl+C to exit.")
+	}
 
 	// Start the live writer
 	tw.writer.Start()
+	tw.writerStarted = true
+
+	// Cancel the in-flight test process as soon as shutdown is requested
+	stopTerminating := context.AfterFunc(ctx, tw.terminateTests)
+	defer stopTerminating()
 
-	// Run tests immediately on startup
-	tw.RunTests()
+	// Run tests immediately on startup, while already reacting to changes
+	if !tw.skipInitialRun && tw.seedGitChanges() {
+		go tw.runScheduledTests("", tw.RunTests)
+	}
 
-	var debounceTimer *time.Timer
+	// Debounce to run tests only once for a burst of changes
+	debounce := newDebouncer(tw.debounceDelay, tw.runChangedTests)
+	if tw.perPackageDebounce {
+		debounce = newKeyedDebouncer(tw.debounceDelay, tw.runDirChangedTests)
+	}
 
 	// Event processing
 	for {
 		select {
+		case <-ctx.Done():
+			return tw.shutdown(debounce)
+
 		case event, ok := <-tw.watcher.Events():
 			if !ok {
+				// Closed with Close
+				debounce.Stop()
 				return nil
 			}
-			// Process write events
-			if event.Has(fsnotify.Write) ||
-				event.Has(fsnotify.Create) {
-				// Apply file filter
-				if tw.fileFilter(event.Name) {
-					// Add the changed file to tracking
-					tw.AddChangedFile(event.Name)
-
-					// Reset timer if already set
-					if debounceTimer != nil {
-						debounceTimer.Stop()
-					}
-					// Debounce to run tests only once for multiple changes
-					debounceTimer = time.AfterFunc(tw.debounceDelay, func() {
-						// Show which file changed
-						fmt.Fprintf(tw.writer, "%s changed. Running tests again.\n", event.Name)
-						tw.writer.Flush()
-						tw.RunTests()
-					})
+			tw.handleEvent(event, debounce)
+
+		case key := <-keys:
+			switch key {
+			case 'r':
+				debounce.Stop()
+				go func() {
+					tw.terminateTests()
+					tw.runScheduledTests("Running all tests.", tw.runAllTests)
+				}()
+			case 'f':
+				go func() {
+					tw.terminateTests()
+					tw.runScheduledTests("Running failed tests.", tw.rerunFailedTests)
+				}()
+			case 'c':
+				go tw.toggleCoverage()
+			case 'n':
+				go tw.showNextFailure()
+			case 's':
+				go tw.showSlowestTests()
+			case 'p':
+				go tw.togglePause()
+			case 'q':
+				return tw.shutdown(debounce)
+			}
+
+		case err, ok := <-tw.watcher.Errors():
+			if !ok {
+				debounce.Stop()
+				return nil
+			}
+			tw.logger.Error("watch error", "err", err)
+		}
+	}
+}
+
+// seedGitChanges adds the files changed in git to the changed files when SetGitChanged is enabled,
+// so the first run only tests the packages they affect. It reports whether the first run should
+// happen, which it shouldn't when git reports no changes. If git fails, every package is tested.
+func (tw *TestWatcher) seedGitChanges() bool {
+	if !tw.gitChanged {
+		return true
+	}
+
+	added, err := tw.addGitChangedFiles()
+	if err != nil {
+		fmt.Fprintf(tw.writer, "Could not find files changed in git, testing all packages: %v\n", err)
+		tw.writer.Flush()
+		return true
+	}
+	if !added {
+		fmt.Fprintf(tw.writer, "No files changed in git, nothing to test.\n")
+		tw.writer.Flush()
+	}
+	return added
+}
+
+// RunOnce runs the tests of every root once without watching for changes, for CI and git hooks.
+// The watcher is closed afterwards. If ctx is done first, the run is cancelled and ctx.Err() is returned.
+// With SetGitChanged, only the packages affected by the files changed in git are tested, if any.
+func (tw *TestWatcher) RunOnce(ctx context.Context) (Result, error) {
+	defer tw.watcher.Close()
+
+	if tw.gitChanged && tw.focusDir == "" {
+		if err := tw.RefreshDependencies(); err != nil {
+			fmt.Printf("Could not build package dependency graph, only changed packages will be tested: %v\n", err)
+		}
+	}
+	if !tw.seedGitChanges() {
+		return Result{Outcome: OutcomePassed}, nil
+	}
+
+	stopTerminating := context.AfterFunc(ctx, tw.terminateTests)
+	defer stopTerminating()
+
+	err := tw.RunTests()
+	tw.writer.Flush()
+	tw.hooks.Wait()
+	if ctx.Err() != nil {
+		return tw.lastResult, ctx.Err()
+	}
+	return tw.lastResult, err
+}
+
+// addWatches watches root and every directory below it that is not hidden, ignored or excluded
+func (tw *TestWatcher) addWatches(root string) error {
+	return tw.walkWatches(root, root, make(map[string]bool), func(path, _ string) error {
+		return tw.addWatch(path)
+	})
+}
+
+// addWatch watches a single directory
+func (tw *TestWatcher) addWatch(dir string) error {
+	if err := tw.watcher.Add(dir); err != nil {
+		return err
+	}
+	tw.logger.Debug("watching directory", "dir", dir)
+	tw.watchedDirs[dir] = true
+	return nil
+}
+
+// walkWatches walks dir and calls watch for its directories with the paths they have below root,
+// and their real paths. They differ when root is a followed symlink and dir its target. visited
+// holds the real paths of the directories walked so far, so a directory is only watched through one path.
+func (tw *TestWatcher) walkWatches(root, dir string, visited map[string]bool, watch func(path, walked string) error) error {
+	return filepath.Walk(dir, func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		path := root
+		if walked != dir {
+			rel, err := filepath.Rel(dir, walked)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(root, rel)
+		}
+
+		isDir := info.IsDir()
+		symlinked := false
+		if tw.followSymlinks && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(walked); err == nil && target.IsDir() {
+				isDir, symlinked = true, true
+			}
+		}
+
+		// Skip hidden and ignored directories
+		if isDir {
+			if strings.HasPrefix(filepath.Base(path), ".") && path != root {
+				return filepath.SkipDir
+			}
+			if tw.isIgnored(path, true) || tw.isExcluded(path) {
+				return filepath.SkipDir
+			}
+			if symlinked {
+				target, err := filepath.EvalSymlinks(walked)
+				if err != nil {
+					return err
+				}
+				// Walk doesn't descend into symlinks, so the target is walked separately
+				return tw.walkWatches(path, target, visited, watch)
+			}
+			if tw.followSymlinks {
+				real, err := filepath.EvalSymlinks(walked)
+				if err != nil {
+					return err
 				}
+				// A symlink back to a directory already watched, such as a parent, would loop forever
+				if visited[real] {
+					tw.logger.Debug("skipping directory already watched through another path", "dir", path)
+					return filepath.SkipDir
+				}
+				visited[real] = true
 			}
+			tw.loadGitignore(path)
+			return watch(path, walked)
+		}
+		if tw.isIgnored(path, false) || tw.isExcluded(path) {
+			return nil
+		}
+		// Remember the content of generated files so header-only regenerations can be ignored
+		if tw.ignoreGenerated && tw.fileFilter(path) {
+			tw.generatedContentChanged(path)
+		}
+		if tw.watchEmbeds {
+			tw.updateEmbedPatterns(path)
+		}
+		tw.importsChanged(path)
+		return nil
+	})
+}
+
+// WatchList returns the directories being watched for changes
+func (tw *TestWatcher) WatchList() []string {
+	return tw.watcher.WatchList()
+}
+
+// removeWatches stops watching dir and the watched directories below it, such as after dir was removed
+func (tw *TestWatcher) removeWatches(dir string) {
+	for watched := range tw.watchedDirs {
+		if containsPath(dir, watched) {
+			// The backend may already have dropped the watch of a removed directory
+			tw.watcher.Remove(watched)
+			delete(tw.watchedDirs, watched)
+			delete(tw.gitignoreRules, watched)
+		}
+	}
+}
+
+// runChangedTests collects the changes since the last run, and if any should run tests,
+// cancels a run that is still testing previous changes and tests the accumulated changes
+func (tw *TestWatcher) runChangedTests() {
+	if tw.pausedChangedRun() || tw.deferChangedRun() {
+		return
+	}
+	if !tw.collectChanges() {
+		return
+	}
+	tw.terminateTests()
+
+	tw.runScheduledTests(changedFilesAnnouncement(tw.changedFileList()), tw.RunTests)
+}
+
+// runDirChangedTests tests the changes to the files in dir once they have settled, when each package is
+// debounced separately. Runs for other packages are not cancelled; the run waits for them to finish, and
+// collects the changes only then so a finishing run doesn't clear them.
+func (tw *TestWatcher) runDirChangedTests(dir string) {
+	if tw.pausedChangedRun() || tw.deferChangedRun() {
+		return
+	}
+	tw.runScheduledTests("", func() error {
+		if !tw.collectDirChanges(dir) {
+			return nil
+		}
+		tw.refreshStaleDependencies()
+
+		fmt.Fprintf(tw.writer, "%s\n", changedFilesAnnouncement(tw.changedFileList()))
+		tw.writer.Flush()
+		return tw.RunTests()
+	})
+}
+
+// changedFilesAnnouncement describes the changed files that triggered a run
+func changedFilesAnnouncement(files []string) string {
+	switch len(files) {
+	case 0:
+		return "Files changed. Running tests again."
+	case 1:
+		return fmt.Sprintf("%s changed. Running tests again.", files[0])
+	default:
+		return fmt.Sprintf("%d files changed. Running tests again.", len(files))
+	}
+}
+
+// runScheduledTests performs test runs one at a time unless the watcher has been shut down
+func (tw *TestWatcher) runScheduledTests(announcement string, run func() error) {
+	tw.runMutex.Lock()
+	defer tw.runMutex.Unlock()
+
+	if tw.closed {
+		return
+	}
+
+	if announcement != "" {
+		fmt.Fprintf(tw.writer, "%s\n", announcement)
+		tw.writer.Flush()
+	}
+
+	tw.refreshStaleDependencies()
+
+	tw.startRun()
+	defer tw.endRun()
+	run()
+}
+
+// refreshStaleDependencies rebuilds the package dependency graph if changes made it stale
+func (tw *TestWatcher) refreshStaleDependencies() {
+	if tw.focusDir != "" || tw.alwaysRunAll {
+		return
+	}
+
+	tw.collectMutex.Lock()
+	stale := tw.dependenciesStale
+	tw.dependenciesStale = false
+	tw.collectMutex.Unlock()
+
+	if stale {
+		if err := tw.RefreshDependencies(); err != nil {
+			fmt.Fprintf(tw.writer, "Could not refresh package dependency graph: %v\n", err)
+		}
+	}
+}
+
+// shutdown stops pending and in-flight test runs, restores the terminal and closes the file watcher,
+// then prints a summary of the session
+func (tw *TestWatcher) shutdown(debounce *debouncer) error {
+	debounce.Stop()
+	err := tw.Close()
+	fmt.Fprint(tw.writer.Bypass(), tw.sessionSummary())
+	return err
+}
+
+// isModuleFile reports whether path is a go.mod, go.sum, go.work or go.work.sum file,
+// which define the module requirements and the modules of a workspace
+func isModuleFile(path string) bool {
+	name := filepath.Base(path)
+	return name == "go.mod" || name == "go.sum" || name == workspaceFile || name == workspaceFile+".sum"
+}
+
+// shouldTrigger reports whether a change to path should run tests
+func (tw *TestWatcher) shouldTrigger(path string) bool {
+	if tw.outsideFocus(path) {
+		tw.logger.Debug("change ignored", "path", path, "reason", "outside the focused package")
+		return false
+	}
+
+	if tw.isIgnored(path, false) || tw.isExcluded(path) {
+		tw.logger.Debug("change ignored", "path", path, "reason", "ignored or excluded")
+		return false
+	}
+
+	if tw.matchesPreRunPatterns(path) {
+		return true
+	}
+
+	// Changed requirements can affect any package, and change the package graph
+	if isModuleFile(path) {
+		tw.dependenciesStale = true
+		tw.modulesChanged = true
+		return true
+	}
+
+	// Vendored packages are not in the package graph, so any package may use them
+	if tw.includeVendor && isVendored(tw.watchDir, path) && tw.fileFilter(path) {
+		tw.modulesChanged = true
+		return true
+	}
+
+	if tw.formatOnSave && tw.isFormatterRewrite(path) {
+		tw.logger.Debug("change ignored", "path", path, "reason", "formatted by the watcher")
+		return false
+	}
+
+	if tw.fileFilter(path) {
+		if tw.watchEmbeds {
+			tw.updateEmbedPatterns(path)
+		}
+		if tw.importsChanged(path) {
+			tw.dependenciesStale = true
+		}
+		if tw.ignoreGenerated && !tw.generatedContentChanged(path) {
+			tw.logger.Debug("change ignored", "path", path, "reason", "generated header only")
+			return false
+		}
+		return true
+	}
+
+	if tw.watchEmbeds {
+		if _, embedded := tw.embeddingPackageDir(path); embedded {
+			return true
+		}
+	}
+	tw.logger.Debug("change ignored", "path", path, "reason", "no match for the file filter")
+	return false
+}
+
+// printEvents reports every received event and whether it passed the file filter without running tests
+func (tw *TestWatcher) printEvents(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return tw.watcher.Close()
+
+		case event, ok := <-tw.watcher.Events():
+			if !ok {
+				return nil
+			}
+			status := "ignored"
+			if tw.fileFilter(event.Name) {
+				status = "matched"
+			}
+			fmt.Printf("%s %s (%s)\n", event.Op, event.Name, status)
 
 		case err, ok := <-tw.watcher.Errors():
 			if !ok {
 				return nil
 			}
-			fmt.Fprintf(tw.writer, "Watch error: %v\n", err)
-			tw.writer.Flush()
+			fmt.Printf("Watch error: %v\n", err)
 		}
 	}
 }
 
-// Stop stops the test watcher
+// Close stops the test watcher without exiting the process, for use as a library.
+// An in-flight test process is terminated and awaited, the terminal is restored and the file watcher
+// is closed, which makes Watch return. Closing an already closed watcher does nothing.
+func (tw *TestWatcher) Close() error {
+	tw.terminateTests()
+
+	// Wait for an in-flight run to finish reporting its results
+	tw.runMutex.Lock()
+	closed := tw.closed
+	tw.closed = true
+	tw.runMutex.Unlock()
+	if closed {
+		return nil
+	}
+	// Runs end before closed is set, so no run can be scheduled after this
+	tw.stopDelayedRun()
+
+	if tw.restoreTerminal != nil {
+		tw.restoreTerminal()
+	}
+	if tw.writerStarted {
+		tw.writer.Stop()
+	}
+	tw.hooks.Wait()
+	return tw.watcher.Close()
+}
+
+// Stop stops the test watcher and exits the process
 func (tw *TestWatcher) Stop() {
-	tw.watcher.Close()
+	tw.Close()
 	os.Exit(0)
 }
 
+// SetPollFilesystems sets the filesystem types on which the polling watcher is used instead of fs events
+func (tw *TestWatcher) SetPollFilesystems(types []string) error {
+	watcher, err := filenotify.NewForPath(tw.watchDir, types)
+	var fallback *filenotify.FallbackError
+	if err != nil && !errors.As(err, &fallback) {
+		return fmt.Errorf("failed to initialize watcher: %w", err)
+	}
+
+	tw.watcher.Close()
+	tw.watcher = watcher
+	tw.watcherFallback = fallback
+	return nil
+}
+
+// ForcePolling makes the watcher poll for changes at the given interval instead of using fs events,
+// for mounted volumes where fs events don't propagate. A non-positive interval uses the default.
+func (tw *TestWatcher) ForcePolling(interval time.Duration) {
+	if interval <= 0 {
+		interval = filenotify.DefaultPollInterval
+	}
+
+	tw.watcher.Close()
+	tw.watcher = filenotify.NewPollingWatcherWithInterval(interval)
+	tw.watcherFallback = nil
+}
+
+// SetIgnorePatterns sets additional patterns in .gitignore syntax, relative to the watch directory,
+// for files and directories that should not be watched. .gitignore files are always honored.
+func (tw *TestWatcher) SetIgnorePatterns(patterns []string) {
+	tw.ignoreRules = nil
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnoreRule(tw.watchDir, pattern); ok {
+			tw.ignoreRules = append(tw.ignoreRules, rule)
+		}
+	}
+}
+
+// SetExcludePatterns sets glob patterns for files and directories whose changes never run tests,
+// matched against base names and paths relative to the watch directory
+func (tw *TestWatcher) SetExcludePatterns(patterns []string) {
+	// Patterns are matched against slash-separated paths, so "testdata\*" works on Windows too
+	tw.excludePatterns = make([]string, len(patterns))
+	for i, pattern := range patterns {
+		tw.excludePatterns[i] = filepath.ToSlash(pattern)
+	}
+}
+
+// SetTriggerOps sets the file operations that run tests, such as fsnotify.Write|fsnotify.Remove.
+// Rename stands for files renamed away, as a file renamed into place is reported as created.
+func (tw *TestWatcher) SetTriggerOps(ops fsnotify.Op) {
+	tw.triggerOps = ops
+}
+
+// SetNewTestFileOnly makes runs triggered by creating a test file test only the package of the new file,
+// leaving out the packages that depend on it
+func (tw *TestWatcher) SetNewTestFileOnly(enabled bool) {
+	tw.newTestFileOnly = enabled
+}
+
+// SetBuildRetries sets how many times a run that failed to build is retried after delay before the
+// build failure is reported, as a file being saved in chunks may have been read half-written (0 disables)
+func (tw *TestWatcher) SetBuildRetries(retries int, delay time.Duration) {
+	tw.buildRetries = retries
+	tw.buildRetryDelay = delay
+}
+
+// SetPerPackageDebounce debounces the changes to each package directory separately, testing each
+// package as soon as its own changes settle rather than once all changes have, for faster first feedback
+// after saving many packages at once
+func (tw *TestWatcher) SetPerPackageDebounce(enabled bool) {
+	tw.perPackageDebounce = enabled
+}
+
 // SetDebounceDelay sets the debounce delay for test runs
 func (tw *TestWatcher) SetDebounceDelay(delay time.Duration) {
 	tw.debounceDelay = delay
 }
 
+// SetTimeout passes -timeout to go test, which fails tests running longer than d with their
+// goroutine stacks. A test command still running a minute after that is killed. Zero disables the timeout.
+func (tw *TestWatcher) SetTimeout(d time.Duration) {
+	tw.timeout = d
+}
+
+// SetParallelPackages sets how many packages go test builds and tests in parallel with -p (0 uses the go default)
+func (tw *TestWatcher) SetParallelPackages(n int) {
+	tw.parallelPackages = n
+}
+
+// SetBatchSize limits how many packages each test command tests, running batches one after another
+// and reporting their results together (0 tests all packages at once)
+func (tw *TestWatcher) SetBatchSize(n int) {
+	tw.batchSize = n
+}
+
+// SetSkipInitialRun skips running the tests when watching starts, so tests only run after changes
+func (tw *TestWatcher) SetSkipInitialRun(skip bool) {
+	tw.skipInitialRun = skip
+}
+
+// SetFullRunEvery makes every run after n incremental runs test all packages (0 disables)
+func (tw *TestWatcher) SetFullRunEvery(n int) {
+	tw.fullRunEvery = n
+}
+
+// SetAlwaysRunAll makes every run test all packages instead of those affected by the changes.
+// The package dependency graph is then not built.
+func (tw *TestWatcher) SetAlwaysRunAll(enabled bool) {
+	tw.alwaysRunAll = enabled
+}
+
+// SetKillGracePeriod sets how long a test process may take to exit after being interrupted before it is killed
+func (tw *TestWatcher) SetKillGracePeriod(period time.Duration) {
+	tw.killGracePeriod = period
+}
+
 // SetFileFilter sets a custom file filter function
 func (tw *TestWatcher) SetFileFilter(filter func(string) bool) {
 	tw.fileFilter = filter
 }
 
+// SetClearScreen makes each test run start on a cleared terminal screen
+func (tw *TestWatcher) SetClearScreen(enabled bool) {
+	tw.clearScreen = enabled
+}
+
+// SetColor sets whether result headlines are colored with ANSI escape sequences
+func (tw *TestWatcher) SetColor(enabled bool) {
+	tw.color = enabled
+}
+
+// SetNotifications enables desktop notifications when the tests go from passing to failing or back
+func (tw *TestWatcher) SetNotifications(enabled bool) {
+	tw.notifications = enabled
+}
+
+// SetDryWatch makes Watch only print received events instead of running tests
+func (tw *TestWatcher) SetDryWatch(enabled bool) {
+	tw.dryWatch = enabled
+}
+
+// SetIgnoreGeneratedHeaders makes changes to generated Go files that only touch
+// their "Code generated ... DO NOT EDIT." header comments not trigger test runs
+func (tw *TestWatcher) SetIgnoreGeneratedHeaders(enabled bool) {
+	tw.ignoreGenerated = enabled
+}
+
+// SetFollowSymlinks makes the watched directories include the directories that symlinks point to.
+// A directory reached again through another symlink, such as one pointing to a parent, is only
+// watched through the first path it was found at, so symlink cycles are not followed.
+func (tw *TestWatcher) SetFollowSymlinks(enabled bool) {
+	tw.followSymlinks = enabled
+}
+
+// SetWatchEmbeds makes changes to files embedded with //go:embed run the tests of the embedding package
+func (tw *TestWatcher) SetWatchEmbeds(enabled bool) {
+	tw.watchEmbeds = enabled
+}
+
+// SetTestCommand sets the command used to run tests instead of "go test".
+// The test flags and package patterns are appended after baseArgs.
+func (tw *TestWatcher) SetTestCommand(name string, baseArgs []string) {
+	tw.testCommand = name
+	tw.testArgs = baseArgs
+}
+
+// SetGoBinary sets the go command used to list packages, and to run tests unless SetTestCommand
+// set a different command, e.g. a go1.22.0 shim or the path of a specific toolchain.
+// The go command honors GOFLAGS and the rest of the environment.
+func (tw *TestWatcher) SetGoBinary(path string) {
+	if tw.testCommand == tw.goBinary {
+		tw.testCommand = path
+	}
+	tw.goBinary = path
+}
+
+// SetRunPattern restricts test runs to the tests matching pattern in the affected packages
+func (tw *TestWatcher) SetRunPattern(pattern string) {
+	tw.runPattern = pattern
+}
+
+// SetBuildTags sets the build tags passed to go test and go list, so files constrained with
+// //go:build lines such as integration are tested and their imports are tracked
+func (tw *TestWatcher) SetBuildTags(tags []string) {
+	tw.buildTags = tags
+}
+
+// SetExtraArgs sets flags passed to the test command after the package patterns, such as
+// -shuffle=on or -failfast. Flags following -args are passed on to the test binaries.
+func (tw *TestWatcher) SetExtraArgs(args []string) {
+	tw.extraArgs = args
+}
+
+// EnableJSON enables running go test with -json to detect results reliably.
+// It has no effect when a custom test command is set, which is parsed as plain text.
+func (tw *TestWatcher) EnableJSON(enabled bool) {
+	tw.withJSON = enabled
+}
+
+// usesJSON reports whether the test output is a go test -json event stream
+func (tw *TestWatcher) usesJSON() bool {
+	return tw.withJSON && tw.testCommand == tw.goBinary
+}
+
+// SetDisableCache makes every run execute the tests instead of reporting cached results
+func (tw *TestWatcher) SetDisableCache(disabled bool) {
+	tw.disableCache = disabled
+}
+
+// EnableRace enables running tests with the race detector
+func (tw *TestWatcher) EnableRace(enabled bool) {
+	tw.withRace = enabled
+}
+
 // EnableCoverage enables test coverage reporting
 func (tw *TestWatcher) EnableCoverage(enabled bool) {
 	tw.withCoverage = enabled
 }
 
-// TrackFailedTest adds a test to the failed tests list
+// EnableTotalCoverage enables reporting of the aggregate statement coverage
+// computed from a coverage profile instead of the per-package percentages
+func (tw *TestWatcher) EnableTotalCoverage(enabled bool) {
+	tw.withTotalCoverage = enabled
+}
+
+// SetCoverPackages measures the coverage of the packages matching patterns, passed to go test as
+// -coverpkg, and reports the total coverage. Packages without tests count towards the total with
+// no covered statements, rather than being left out.
+func (tw *TestWatcher) SetCoverPackages(patterns []string) {
+	tw.coverPackages = patterns
+}
+
+// reportsTotalCoverage reports whether runs write coverage profiles to compute the total coverage
+func (tw *TestWatcher) reportsTotalCoverage() bool {
+	return tw.withTotalCoverage || tw.coverageThreshold > 0 || len(tw.coverPackages) > 0
+}
+
+// SetCoverageThreshold sets the minimum total coverage percentage of the tested packages.
+// Runs whose tests pass with lower coverage are reported as failures. Zero disables the check.
+func (tw *TestWatcher) SetCoverageThreshold(percent float64) {
+	tw.coverageThreshold = percent
+}
+
+// EnableLint enables running the linter on the tested packages after each test run
+func (tw *TestWatcher) EnableLint(enabled bool) {
+	tw.withLint = enabled
+}
+
+// SetLintCommand sets the linter command, which receives the package patterns after baseArgs
+func (tw *TestWatcher) SetLintCommand(name string, baseArgs []string) {
+	tw.lintCommand = name
+	tw.lintArgs = baseArgs
+}
+
+// SetVerbose sets whether tests run with -v. When disabled, passing runs only show
+// the summary line and failing runs show the output of the failed tests.
+func (tw *TestWatcher) SetVerbose(enabled bool) {
+	tw.verbose = enabled
+}
+
+// SetFailedFirst makes runs start with only the previously failed tests,
+// and run the affected packages once those pass
+func (tw *TestWatcher) SetFailedFirst(enabled bool) {
+	tw.failedFirst = enabled
+}
+
+// TrackFailedTest adds a test, named package/TestName, to the failed tests list
 func (tw *TestWatcher) TrackFailedTest(testName string) {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
 	tw.failedTests[testName] = true
 }
 
 // ClearFailedTests clears the failed tests list
 func (tw *TestWatcher) ClearFailedTests() {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
 	tw.failedTests = make(map[string]bool)
 }
 
-// FindAffectedPackages finds packages affected by changes in the given file
+// failedTestList returns the tracked failed tests, sorted
+func (tw *TestWatcher) failedTestList() []string {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
+	return slices.Sorted(maps.Keys(tw.failedTests))
+}
+
+// FindAffectedPackages finds packages affected by changes in the given file, as package
+// patterns for go test run in the watch directory, such as "." or "./internal/calc"
 func (tw *TestWatcher) FindAffectedPackages(changedFile string) []string {
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+
 	// Get the package of the changed file
 	dir := filepath.Dir(changedFile)
+	if packageDir, ok := tw.embeddingPackageDir(changedFile); ok && filepath.Ext(changedFile) != ".go" {
+		dir = packageDir
+	}
 	relDir, err := filepath.Rel(tw.watchDir, dir)
 	if err != nil {
 		// If we can't determine the relative path, just use the directory
 		relDir = dir
 	}
 
-	// Convert path separator to package separator
-	pkg := strings.ReplaceAll(relDir, string(filepath.Separator), "/")
+	// The dependency graph is keyed by slash-separated directories, relative to the watch directory
+	pkg := path.Clean(filepath.ToSlash(relDir))
 
 	// Add the package itself
-	affectedPackages := []string{pkg}
+	affectedPackages := []string{packagePattern(relDir)}
 
 	// Add dependent packages (if known)
-	if deps, ok := tw.packageDependencies[pkg]; ok {
-		affectedPackages = append(affectedPackages, deps...)
+	for _, dep := range tw.packageDependencies[pkg] {
+		affectedPackages = append(affectedPackages, packagePattern(dep))
 	}
 
 	return affectedPackages
 }
 
-// BuildTestArgs builds the go test command arguments based on changed files and failed tests
+// BuildTestArgs builds the test command arguments based on changed files and failed tests
 func (tw *TestWatcher) BuildTestArgs() []string {
-	args := []string{"test", "-v"}
+	return tw.buildArgs(tw.runPattern, tw.packagesToTest())
+}
+
+// buildArgs builds the test command arguments for the tests matching runPattern in packages
+func (tw *TestWatcher) buildArgs(runPattern string, packages []string) []string {
+	args := slices.Clone(tw.testArgs)
+	if len(tw.buildTags) > 0 {
+		args = append(args, "-tags="+strings.Join(tw.buildTags, ","))
+	}
+
+	if tw.verbose {
+		args = append(args, "-v")
+	}
+
+	if tw.usesJSON() {
+		args = append(args, "-json")
+	}
+
+	if tw.withRace {
+		args = append(args, "-race")
+	}
+
+	if tw.disableCache {
+		args = append(args, "-count=1")
+	}
 
 	if tw.withCoverage {
 		args = append(args, "-cover")
 	}
 
+	if len(tw.coverPackages) > 0 {
+		args = append(args, "-coverpkg="+strings.Join(tw.coverPackages, ","))
+	}
+
+	if tw.coverProfile != "" {
+		args = append(args, "-coverprofile="+tw.coverProfile)
+	}
+
+	if tw.timeout > 0 {
+		args = append(args, "-timeout", tw.timeout.String())
+	}
+
+	if tw.parallelPackages > 0 {
+		args = append(args, "-p", strconv.Itoa(tw.parallelPackages))
+	}
+
+	if tw.benchPattern != "" {
+		// Skip the tests so only benchmarks run
+		args = append(args, "-bench", tw.benchPattern, "-run", "^$")
+	} else if runPattern != "" {
+		args = append(args, "-run", runPattern)
+	}
+
+	// Extra flags go after the packages, where go test accepts test flags as well as -args
+	args = append(args, packages...)
+	return append(args, tw.extraArgs...)
+}
+
+// packagesToTest returns the package patterns to test based on changed files and failed tests
+func (tw *TestWatcher) packagesToTest() []string {
+	if tw.focusDir != "" {
+		return []string{tw.focusPattern()}
+	}
+
+	changedFiles, failedTests := tw.changedFileList(), tw.failedTestList()
+
 	// If we have no changed files and no failed tests, run all tests
-	if len(tw.changedFiles) == 0 && len(tw.failedTests) == 0 {
-		args = append(args, "./...")
-		return args
+	if tw.runAll || tw.alwaysRunAll || len(changedFiles) == 0 && len(failedTests) == 0 {
+		return []string{"./..."}
 	}
 
 	// Collect packages to test
 	packagesToTest := make(map[string]bool)
 
 	// Add packages for changed files
-	for file := range tw.changedFiles {
-		for _, pkg := range tw.FindAffectedPackages(file) {
+	for _, file := range changedFiles {
+		affected := tw.FindAffectedPackages(file)
+		if tw.newTestFileOnly && tw.isAddedTestFile(file) {
+			// Only the package of a new test file has new tests
+			affected = affected[:1]
+		}
+		tw.logger.Debug("packages selected", "file", file, "packages", affected)
+		for _, pkg := range affected {
 			packagesToTest[pkg] = true
 		}
 	}
 
 	// Add packages for failed tests
-	for test := range tw.failedTests {
-		// Extract package from test name (assuming format like Package/TestName)
-		parts := strings.Split(test, "/")
-		if len(parts) > 0 {
-			packagesToTest[parts[0]] = true
+	for _, test := range failedTests {
+		// Extract package from test name (format is package/TestName)
+		if index := strings.LastIndex(test, "/"); index >= 0 {
+			packagesToTest[packagePattern(test[:index])] = true
 		}
 	}
 
 	// If we couldn't determine any specific packages, test everything
 	if len(packagesToTest) == 0 {
-		args = append(args, "./...")
-		return args
+		return []string{"./..."}
 	}
 
-	// Add specific packages to test
-	for pkg := range packagesToTest {
-		if pkg == "." || pkg == "" {
-			// Root package
-			args = append(args, ".")
-		} else {
-			// Subpackage
-			args = append(args, "./"+pkg)
-		}
+	return slices.Sorted(maps.Keys(packagesToTest))
+}
+
+// runsFailedFirst reports whether a run tests the failed tests first, with SetFailedFirst
+func (tw *TestWatcher) runsFailedFirst() bool {
+	return tw.failedFirst && tw.benchPattern == "" && len(tw.failedTestList()) > 0
+}
+
+// selectPackages returns the package patterns a run tests and whether they are all packages. Besides
+// the packages affected by the changes, all packages are tested after SetFullRunEvery incremental runs
+// and when the module requirements changed, for the reason returned.
+func (tw *TestWatcher) selectPackages() ([]string, bool, string) {
+	packages := tw.packagesToTest()
+	if slices.Equal(packages, []string{"./..."}) {
+		return packages, true, ""
+	}
+	if tw.focusDir != "" {
+		// Only the focused package is ever tested
+		return packages, false, ""
+	}
+
+	// Catch what a stale dependency graph may have left out of the incremental runs
+	if tw.fullRunEvery > 0 && tw.incrementalRuns >= tw.fullRunEvery {
+		return []string{"./..."}, true, fmt.Sprintf("Running all tests after %d incremental runs.", tw.incrementalRuns)
+	}
+	tw.collectMutex.Lock()
+	defer tw.collectMutex.Unlock()
+	if tw.modulesChanged {
+		return []string{"./..."}, true, "Module requirements changed. Running all tests."
+	}
+	return packages, false, ""
+}
+
+// countRun counts the incremental runs since all packages were last tested, after a run of the
+// packages from selectPackages
+func (tw *TestWatcher) countRun(full bool) {
+	if full {
+		tw.incrementalRuns = 0
+		tw.collectMutex.Lock()
+		tw.modulesChanged = false
+		tw.collectMutex.Unlock()
+	} else {
+		tw.incrementalRuns++
 	}
+}
 
-	return args
+// LastResult returns the summary of the most recent test run
+func (tw *TestWatcher) LastResult() Result {
+	return tw.lastResult
 }
 
 // AddChangedFile marks a file as changed
 func (tw *TestWatcher) AddChangedFile(file string) {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
 	tw.changedFiles[file] = true
 	tw.lastChangedFile = file
 }
 
 // ClearChangedFiles clears the list of changed files
 func (tw *TestWatcher) ClearChangedFiles() {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
 	tw.changedFiles = make(map[string]bool)
+	tw.newTestFiles = make(map[string]bool)
+}
+
+// removeChangedFile forgets a changed file
+func (tw *TestWatcher) removeChangedFile(file string) {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
+	delete(tw.changedFiles, file)
+}
+
+// changedFileList returns the changed files, sorted
+func (tw *TestWatcher) changedFileList() []string {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
+	return slices.Sorted(maps.Keys(tw.changedFiles))
+}
+
+// addTestFile marks a newly created test file as changed
+func (tw *TestWatcher) addTestFile(file string) {
+	tw.AddChangedFile(file)
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
+	tw.newTestFiles[file] = true
+}
+
+// isAddedTestFile reports whether a changed file is a test file created since the last run
+func (tw *TestWatcher) isAddedTestFile(file string) bool {
+	tw.stateMutex.Lock()
+	defer tw.stateMutex.Unlock()
+	return tw.newTestFiles[file]
 }
 
 // RunTests runs the go tests in the watch directory
 func (tw *TestWatcher) RunTests() error {
+	// Nothing is run, not even the pre-run hook, so the selection can be checked safely
+	if tw.dryRun {
+		tw.printDryRun()
+		tw.ClearChangedFiles()
+		return nil
+	}
+
+	if !tw.runPreRunHook() {
+		return nil
+	}
+
+	// Give quick feedback on the tests being fixed before running everything affected
+	// Lint runs once per cycle, on the packages of the run that ends it
+	if tw.runsFailedFirst() {
+		runs := tw.failedTestRuns()
+		err := tw.runTests("", runs)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if tw.lastResult.Outcome != OutcomePassed {
+			tw.lintRuns(runs)
+			tw.handleOutcome(tw.lastResult)
+			tw.ClearChangedFiles()
+			return err
+		}
+	}
+
+	packages, full, reason := tw.selectPackages()
+	if reason != "" {
+		fmt.Fprintln(tw.writer, reason)
+	}
+
+	runs := tw.packageRuns(packages)
+	err := tw.runTests(tw.runPattern, runs)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	tw.countRun(full)
+	tw.lintRuns(runs)
+	tw.handleOutcome(tw.lastResult)
+
+	// Clear tracked changed files after running tests
+	tw.ClearChangedFiles()
+	return err
+}
+
+// runTests runs the tests matching runPattern in the packages of runs and displays their results.
+// The runs that have a -run pattern of their own use it instead.
+func (tw *TestWatcher) runTests(runPattern string, runs []rootRun) error {
+	if tw.clearScreen && !tw.plainOutput {
+		// Bypass the live writer so it doesn't try to redraw lines that are gone
+		fmt.Fprint(tw.writer.Bypass(), "\033[H\033[2J")
+	}
+
 	fmt.Fprintf(tw.writer, "Running tests...\n")
 	tw.writer.Flush()
+	tw.failureSections = nil
 
-	// Build test arguments based on changed files and failed tests
-	args := tw.BuildTestArgs()
-
-	if len(tw.changedFiles) > 0 {
-		filesList := make([]string, 0, len(tw.changedFiles))
-		for file := range tw.changedFiles {
+	var filesLine string
+	if changedFiles := tw.changedFileList(); tw.verbose && len(changedFiles) > 0 {
+		filesList := make([]string, 0, len(changedFiles))
+		for _, file := range changedFiles {
 			filesList = append(filesList, filepath.Base(file))
 		}
-		fmt.Fprintf(tw.writer, "Files changed: %s\n", strings.Join(filesList, ", "))
+		slices.Sort(filesList)
+		filesLine = fmt.Sprintf("Files changed: %s\n", strings.Join(filesList, ", "))
+		fmt.Fprint(tw.writer, filesLine)
+	}
+
+	var formatFailures string
+	if tw.formatOnSave {
+		formatFailures = tw.formatChangedFiles()
 	}
 
-	cmd := exec.Command("go", args...)
-	cmd.Dir = tw.watchDir
+	// One cancellation covers every process of the run, including build and flaky test retries
+	ctx, endRun := tw.startCancelableRun()
+	defer endRun()
+
+	// Run the command in each root, capturing all output
+	stopTimer := tw.showElapsed(filesLine)
+	output, coverProfiles, err := tw.runProcesses(ctx, runPattern, runs)
+	// A file caught in the middle of a save doesn't compile, so a build failure is retried before it is reported
+	for retry := 0; retry < tw.buildRetries && isBuildFailure(err, output.stdout.String(), output.stderr.String()); retry++ {
+		tw.logger.Debug("build failed, retrying", "delay", tw.buildRetryDelay)
+		removeFiles(coverProfiles)
+		select {
+		case <-ctx.Done():
+		case <-time.After(tw.buildRetryDelay):
+		}
+		output, coverProfiles, err = tw.runProcesses(ctx, runPattern, runs)
+	}
+	stopTimer()
+	defer removeFiles(coverProfiles)
+	if errors.Is(err, context.Canceled) {
+		// A newer change or shutdown superseded this run, so its partial output is not a result
+		return err
+	}
 
-	// Capture all output
-	var output bytes.Buffer
-	cmd.Stdout = &output
-	cmd.Stderr = &output
+	// Results are parsed from stdout, and the combined output is shown
+	outputStr := output.combined.String()
 
-	// Run the command
-	err := cmd.Run()
+	// The history of previous runs and formatting failures are shown together with the test results
+	if tw.verbose {
+		fmt.Fprint(tw.writer, tw.historyStrip())
+	}
+	fmt.Fprint(tw.writer, formatFailures)
 
-	// Parse the output to get a summary
-	outputStr := output.String()
+	result, failureSections, stdoutStr := tw.parseResults(output)
+	if tw.usesJSON() {
+		outputStr = parseJSONEvents(outputStr).output
+	}
+	tw.lastResult = result
 
-	// Clear tracked changed files after running tests
-	tw.ClearChangedFiles()
+	if errors.Is(err, errHardTimeout) {
+		tw.lastResult.Outcome = OutcomeTimedOut
+		message := fmt.Sprintf("TIMEOUT: tests did not finish within %s and were killed", tw.timeout+hardTimeoutMargin)
+		fmt.Fprintf(tw.writer, "%s\n%s\n", tw.colorize(colorRed, message), outputStr)
+		tw.writer.Flush()
+		return err
+	}
 
 	// Check if this is a build failure
-	if err != nil && strings.Contains(outputStr, "build failed") || strings.Contains(outputStr, "does not compile") {
-		fmt.Fprintf(tw.writer, "BUILD FAILED:\n%s\n", outputStr)
+	if isBuildFailure(err, stdoutStr, output.stderr.String()) || err != nil && result.BuildFailed {
+		tw.lastResult.Outcome = OutcomeBuildFailed
+		fmt.Fprintf(tw.writer, "%s\n%s\n", tw.colorize(colorYellow, "BUILD FAILED:"), outputStr)
 		tw.writer.Flush()
-		fmt.Print("\a") // Play bell sound
+		return err
+	}
+
+	if report, ok := parseTimeout(outputStr); ok {
+		tw.lastResult.Outcome = OutcomeTimedOut
+		handleTimedOutTests(tw, report)
 		return err
 	}
 
 	// Count actual failed tests
-	failCount := strings.Count(outputStr, "--- FAIL")
+	failCount := strings.Count(stdoutStr, "--- FAIL")
+	if tw.usesJSON() {
+		failCount = result.Failed
+	}
+
+	// Tests that pass when re-run in isolation are reported as flaky rather than failed
+	if tw.flakyRetries > 0 && tw.benchPattern == "" && len(result.FailedTests) > 0 {
+		flaky, retryErr := tw.retryFailedTests(ctx, result)
+		if retryErr != nil {
+			return retryErr
+		}
+		if len(flaky) > 0 {
+			removeFlakyTests(&tw.lastResult, flaky)
+			fmt.Fprint(tw.writer, tw.flakyReport(flaky))
+			if len(failureSections) == 0 {
+				failureSections = extractTestSections(outputStr)
+			}
+			failureSections = withoutFlakySections(failureSections, flaky)
+			failCount = len(tw.lastResult.FailedTests)
+			if failCount == 0 && len(tw.lastResult.FailedPackages) == 0 {
+				err = nil
+			}
+		}
+	}
 
 	// Process test results
 	if err != nil || failCount > 0 {
-		handleFailedTests(tw, outputStr)
-		fmt.Print("\a") // Play bell sound
+		tw.lastResult.Outcome = OutcomeFailed
+		handleFailedTests(tw, outputStr, failureSections)
 		return err
-	} else {
-		handleSuccessfulTests(tw, outputStr)
+	}
+
+	if tw.coverageThreshold > 0 && !tw.coverageMet(tw.lastResult) {
+		tw.ClearFailedTests()
+		tw.lastResult.Outcome = OutcomeFailed
 		return nil
 	}
+
+	tw.lastResult.Outcome = OutcomePassed
+	handleSuccessfulTests(tw, outputStr)
+	return nil
+}
+
+// parseResults parses the result of each test process of a run and merges them into the result of the run.
+// It also returns the failure sections of JSON output, and the stdout of the processes as text.
+func (tw *TestWatcher) parseResults(output *processOutput) (Result, []string, string) {
+	var results []Result
+	var failureSections []string
+	var stdout strings.Builder
+	for _, run := range output.runs {
+		var result Result
+		if tw.usesJSON() {
+			events := parseJSONEvents(run.stdout)
+			result = events.result
+			failureSections = append(failureSections, events.failures...)
+			stdout.WriteString(events.output)
+		} else {
+			result = parseTextResult(run.stdout)
+			stdout.WriteString(run.stdout)
+		}
+		if run.coverProfile != "" {
+			result.CoverProfiles = []string{run.coverProfile}
+		}
+		results = append(results, result)
+	}
+
+	merged := MergeResults(results...)
+	// Packages without tests only count when -coverpkg measures them through the tests of other packages
+	if merged.HasCoverage && len(tw.coverPackages) > 0 {
+		if total, err := totalCoverage(nil, merged.CoverProfiles...); err == nil {
+			merged.Coverage = total
+		}
+	}
+	return merged, failureSections, stdout.String()
+}
+
+// coverageMet reports whether result meets the coverage threshold, reporting coverage that is too low
+func (tw *TestWatcher) coverageMet(result Result) bool {
+	if !result.HasCoverage {
+		fmt.Fprintf(tw.writer, "%s\n", tw.colorize(colorRed, "COVERAGE UNKNOWN: no coverage profile was written to check the minimum coverage"))
+		tw.writer.Flush()
+		return false
+	}
+	if result.Coverage >= tw.coverageThreshold {
+		return true
+	}
+
+	message := fmt.Sprintf("COVERAGE TOO LOW: total coverage %.1f%% is below the minimum of %.1f%%", result.Coverage, tw.coverageThreshold)
+	fmt.Fprintf(tw.writer, "%s\n", tw.colorize(colorRed, message))
+	tw.writer.Flush()
+	return false
+}
+
+// compilerErrorPattern matches compiler error lines such as "./calc.go:12:3: undefined: x"
+var compilerErrorPattern = regexp.MustCompile(`^\S+\.go:\d+:\d+: `)
+
+// runProcesses runs the test command for each of runs, capturing all output.
+// It returns the coverage profiles written by the runs, which the caller removes, and
+// context.Canceled without starting the remaining runs once the run of ctx is cancelled.
+func (tw *TestWatcher) runProcesses(ctx context.Context, runPattern string, runs []rootRun) (*processOutput, []string, error) {
+	var output processOutput
+	var coverProfiles []string
+	stdout, stderr := output.writers()
+	var err error
+	for _, run := range runs {
+		// A cancelled run doesn't start its remaining roots and batches
+		if ctx.Err() != nil {
+			return &output, coverProfiles, context.Canceled
+		}
+
+		// A profile also gives -cover the aggregate coverage of the tested packages
+		if tw.reportsTotalCoverage() || tw.withCoverage {
+			profile, err := os.CreateTemp("", "go-test-watcher-*.coverprofile")
+			if err != nil {
+				fmt.Fprintf(tw.writer, "Could not create coverage profile: %v\n", err)
+			} else {
+				profile.Close()
+				tw.coverProfile = profile.Name()
+				coverProfiles = append(coverProfiles, tw.coverProfile)
+			}
+		}
+
+		pattern := runPattern
+		if run.runPattern != "" {
+			pattern = run.runPattern
+		}
+		args := tw.buildArgs(pattern, run.packages)
+		tw.logger.Debug("running tests", "dir", run.dir, "packages", run.packages, "command", tw.testCommand, "args", args)
+		runErr := tw.runTestProcess(ctx, run.dir, args, stdout, stderr)
+		output.endRun(tw.coverProfile)
+		tw.coverProfile = ""
+		if errors.Is(runErr, context.Canceled) {
+			return &output, coverProfiles, runErr
+		}
+		if err == nil {
+			err = runErr
+		}
+	}
+	return &output, coverProfiles, err
+}
+
+// removeFiles removes the files at paths, ignoring errors
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// isBuildFailure reports whether a failed test run failed because the packages did not compile.
+// It looks for the lines go test prints for such packages and for compiler output on stderr, rather
+// than for phrases such as "build failed" that a test may log.
+func isBuildFailure(err error, stdout, stderr string) bool {
+	if err == nil {
+		return false
+	}
+	for _, line := range strings.Split(stdout, "\n") {
+		if buildFailedPattern.MatchString(line) {
+			return true
+		}
+	}
+	return hasCompilerOutput(stderr)
+}
+
+// hasCompilerOutput reports whether output has compiler errors, which follow a "# example.com/calc"
+// header line naming the package, or several such as for vet errors
+func hasCompilerOutput(output string) bool {
+	header := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			header = true
+		case header && compilerErrorPattern.MatchString(line):
+			return true
+		default:
+			header = false
+		}
+	}
+	return false
+}
+
+// handleOutcome reacts to the outcome of a completed test run
+func (tw *TestWatcher) handleOutcome(result Result) {
+	tw.recordRun(result)
+
+	previous := tw.previousOutcome
+	tw.previousOutcome = result.Outcome
+
+	// The first run has nothing to transition from
+	transitioned := previous != "" && (previous == OutcomePassed) != (result.Outcome == OutcomePassed)
+	if result.Outcome != OutcomePassed {
+		tw.ringBell(previous)
+	}
+	if tw.notifications && transitioned {
+		notifyTransition(tw, result)
+	}
+	if tw.webhookURL != "" && transitioned {
+		tw.postWebhook(result)
+	}
+
+	if tw.jsonOutput != nil {
+		tw.writeRunReport(result)
+	}
+	if tw.summaryFile != "" {
+		if err := tw.writeSummaryFile(result); err != nil {
+			fmt.Fprintf(tw.writer, "Could not write summary: %v\n", err)
+			tw.writer.Flush()
+		}
+	}
+
+	tw.runOutcomeHook(result)
+}
+
+// notifyTransition shows a desktop notification for a change between passing and failing
+func notifyTransition(tw *TestWatcher, result Result) {
+	title, message := transitionMessage(result)
+	if err := notify.Send(title, message); err != nil {
+		fmt.Fprintf(tw.writer, "Could not show notification: %v\n", err)
+		tw.writer.Flush()
+	}
+}
+
+// transitionMessage describes the outcome of a run that changed the state of the tests
+func transitionMessage(result Result) (string, string) {
+	duration := fmt.Sprintf("%.3fs", result.Duration.Seconds())
+
+	title := "Tests passing"
+	message := fmt.Sprintf("All tests passed (%s)", duration)
+	switch result.Outcome {
+	case OutcomeBuildFailed:
+		title = "Build failed"
+		message = fmt.Sprintf("Build failed (%s)", duration)
+	case OutcomeTimedOut:
+		title = "Tests timed out"
+		message = fmt.Sprintf("%d tests timed out (%s)", result.TimedOut, duration)
+	case OutcomeFailed:
+		title = "Tests failing"
+		message = fmt.Sprintf("%d tests failed (%s)", result.Failed, duration)
+	}
+	return title, message
 }
 
-// handleFailedTests processes and displays failed test results
-func handleFailedTests(tw *TestWatcher, outputStr string) {
+// handleFailedTests processes and displays failed test results.
+// The sections are extracted from the output when testSections is empty.
+func handleFailedTests(tw *TestWatcher, outputStr string, testSections []string) {
 	// Extract test sections for better output formatting
-	testSections := extractTestSections(outputStr)
+	if len(testSections) == 0 {
+		testSections = extractTestSections(outputStr)
+	}
+
+	tw.trackFailures(tw.lastResult)
 
-	fmt.Fprintf(tw.writer, "TEST FAILURES:\n\n")
+	if len(tw.lastResult.PackageResults) > 1 {
+		tw.writePackageTable(tw.lastResult.PackageResults)
+	}
+
+	fmt.Fprintf(tw.writer, "%s\n\n", tw.colorize(colorRed, "TEST FAILURES:"))
 
 	if len(testSections) > 0 {
-		// Print each section
-		for _, section := range testSections {
-			fmt.Fprintf(tw.writer, "%s\n\n", section)
-		}
+		// Print each section, its failed assertions first
+		tw.writeFailureSections(testSections)
 	} else {
 		// If no specific sections found, show the full output
 		fmt.Fprintf(tw.writer, "%s\n", outputStr)
@@ -323,6 +1677,21 @@ func handleFailedTests(tw *TestWatcher, outputStr string) {
 	tw.writer.Flush()
 }
 
+// handleTimedOutTests displays the tests aborted by the go test timeout with their goroutine stacks
+func handleTimedOutTests(tw *TestWatcher, report timeoutReport) {
+	tests := "a test"
+	if len(report.tests) > 0 {
+		tests = strings.Join(report.tests, ", ")
+	}
+	fmt.Fprintf(tw.writer, "%s\n\n", tw.colorize(colorRed, fmt.Sprintf("TIMEOUT: %s timed out after %s", tests, report.after)))
+
+	for _, stack := range report.stacks {
+		fmt.Fprintf(tw.writer, "%s\n\n", stack)
+	}
+
+	tw.writer.Flush()
+}
+
 // handleSuccessfulTests processes and displays successful test results
 func handleSuccessfulTests(tw *TestWatcher, outputStr string) {
 	// Clear failed tests since all tests passed
@@ -367,101 +1736,135 @@ func handleSuccessfulTests(tw *TestWatcher, outputStr string) {
 		}
 	}
 
+	if tw.usesJSON() && tw.lastResult.Duration > 0 {
+		duration = fmt.Sprintf("%.3fs", tw.lastResult.Duration.Seconds())
+	}
+
+	if tw.reportsTotalCoverage() && tw.lastResult.HasCoverage {
+		coverage = fmt.Sprintf("total coverage: %.1f%%", tw.lastResult.Coverage)
+		if len(tw.lastResult.UncoveredPackages) > 0 {
+			message := "NO COVERAGE: " + strings.Join(tw.lastResult.UncoveredPackages, ", ")
+			fmt.Fprintf(tw.writer, "%s\n", tw.colorize(colorYellow, message))
+		}
+	}
+
+	// With several packages, the coverage of each is listed and the line shows their aggregate
+	covered := coveredPackages(tw.lastResult.PackageResults)
+	if tw.withCoverage && len(covered) > 1 && tw.lastResult.HasCoverage && !tw.reportsTotalCoverage() {
+		coverage = fmt.Sprintf("total coverage: %.1f%%", tw.lastResult.Coverage)
+	}
+
+	if tw.verbose && len(tw.lastResult.PackageResults) > 1 {
+		tw.writePackageTable(tw.lastResult.PackageResults)
+	} else if tw.withCoverage && len(covered) > 1 {
+		tw.writeCoverageList(covered)
+	}
+
+	if len(tw.lastResult.Benchmarks) > 0 {
+		tw.writeBenchmarks(tw.lastResult.Benchmarks)
+	}
+
 	// Format the success message with coverage information if available
 	testResult := "ALL TESTS PASSED"
+	if tw.benchPattern != "" {
+		testResult = "ALL BENCHMARKS PASSED"
+	}
 	if duration != "" && duration != "()" {
-		testResult = fmt.Sprintf("ALL TESTS PASSED (%s)", duration)
+		testResult = fmt.Sprintf("%s (%s)", testResult, duration)
 	}
 	if coverage != "" {
 		testResult += fmt.Sprintf(" - %s", coverage)
 	}
 
-	fmt.Fprintf(tw.writer, "%s\n", testResult)
+	fmt.Fprintf(tw.writer, "%s\n", tw.colorize(colorGreen, testResult))
 	tw.writer.Flush()
 }
 
 // Helper functions for parsing test output
 
-// extractTestSections extracts formatted test sections from the go test output
+// extractTestSections extracts a section of go test output for each failed top-level test. A section has the
+// output of the test and of its failed subtests, leaving out the output of passing subtests, followed by the
+// "--- FAIL" lines naming the test and each failed subtest, and any output after them such as a panic.
 func extractTestSections(output string) []string {
-	// First, split the output into lines and locate all test sections
-	lines := strings.Split(output, "\n")
-
-	// Map to hold sections by test name
-	sectionMap := make(map[string][]string)
-
-	// Track current test being processed
-	var currentTest string
-	var currentLines []string
-	inTestSection := false
-
-	// First pass: collect all test sections
-	for _, line := range lines {
-		// Start of a new test section
-		if strings.Contains(line, "=== RUN") {
-			// If we were tracking a previous test, store it
-			if inTestSection && currentTest != "" {
-				sectionMap[currentTest] = currentLines
-			}
-
-			// Get test name
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				currentTest = parts[2]
-			} else {
-				currentTest = ""
+	var sections []string
+
+	// Test names are only unique within a package, so the tests of each package are grouped separately
+	var started, failed []string
+	blocks := make(map[string][]string)
+	tails := make(map[string][]string)
+	failedNames := make(map[string]bool)
+	current, after := "", ""
+
+	flush := func() {
+		for _, test := range failed {
+			var lines []string
+			for _, name := range started {
+				if name == test || strings.HasPrefix(name, test+"/") && failedNames[name] {
+					lines = append(lines, blocks[name]...)
+				}
 			}
-
-			// Start new section
-			currentLines = []string{line}
-			inTestSection = true
-			continue
+			lines = append(lines, tails[test]...)
+			sections = append(sections, strings.TrimSpace(strings.Join(lines, "\n")))
 		}
+		started, failed = nil, nil
+		blocks = make(map[string][]string)
+		tails = make(map[string][]string)
+		failedNames = make(map[string]bool)
+		current, after = "", ""
+	}
 
-		// End of a test section or continuation
-		if inTestSection {
-			currentLines = append(currentLines, line)
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		fields := strings.Fields(trimmed)
 
-			// Check for end of test
-			if strings.Contains(line, "--- FAIL:") || strings.Contains(line, "--- PASS:") {
-				// Mark this line as the end of the test output
-				if currentTest != "" {
-					sectionMap[currentTest] = currentLines
+		switch {
+		case strings.HasPrefix(line, "=== RUN") || strings.HasPrefix(line, "=== CONT"):
+			// Output of parallel tests continues after a CONT line
+			current, after = "", ""
+			if len(fields) < 3 {
+				continue
+			}
+			current = fields[2]
+			if fields[1] == "RUN" {
+				if _, seen := blocks[current]; !seen {
+					started = append(started, current)
 				}
-			} else if strings.HasPrefix(line, "FAIL") || strings.HasPrefix(line, "ok") || line == "" {
-				// End of section
-				inTestSection = false
-				currentTest = ""
+				blocks[current] = append(blocks[current], line)
 			}
-		}
-	}
 
-	// Make sure we store the last test section if we were processing one
-	if inTestSection && currentTest != "" {
-		sectionMap[currentTest] = currentLines
-	}
+		case strings.HasPrefix(line, "=== "):
+			// PAUSE and NAME lines only mark where tests are
+
+		case len(fields) >= 3 && fields[0] == "---" && strings.HasSuffix(fields[1], ":"):
+			current = ""
+			name, isFail := fields[2], fields[1] == "FAIL:"
+			top, _, _ := strings.Cut(name, "/")
+			if name == top {
+				after = ""
+				if isFail {
+					failed = append(failed, top)
+					after = top
+				}
+			}
+			// The results of subtests follow the result of their test, and passing ones are left out
+			if isFail {
+				failedNames[name] = true
+				if after == top {
+					tails[top] = append(tails[top], line)
+				}
+			}
 
-	// Second pass: identify failed tests
-	var failedTests []string
+		case !strings.HasPrefix(line, " ") && len(fields) > 0 && slices.Contains([]string{"ok", "FAIL", "PASS", "?"}, fields[0]):
+			flush()
 
-	for _, line := range lines {
-		if strings.Contains(line, "--- FAIL:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				failedTests = append(failedTests, parts[2])
-			}
-		}
-	}
+		case current != "":
+			blocks[current] = append(blocks[current], line)
 
-	// Build result with sections for failed tests only
-	var result []string
-	for _, test := range failedTests {
-		if lines, ok := sectionMap[test]; ok {
-			// Join the lines for this test section
-			section := strings.Join(lines, "\n")
-			result = append(result, strings.TrimSpace(section))
+		case after != "":
+			tails[after] = append(tails[after], line)
 		}
 	}
+	flush()
 
-	return result
+	return sections
 }
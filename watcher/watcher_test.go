@@ -0,0 +1,1048 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatcher is a filenotify.FileWatcher whose events are sent by the test
+type fakeWatcher struct {
+	events    chan fsnotify.Event
+	errors    chan error
+	mu        sync.Mutex
+	watched   map[string]bool
+	closeOnce sync.Once
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		watched: make(map[string]bool),
+	}
+}
+
+func (w *fakeWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fakeWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *fakeWatcher) Add(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watched[name] = true
+	return nil
+}
+
+func (w *fakeWatcher) Remove(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.watched, name)
+	return nil
+}
+
+func (w *fakeWatcher) WatchList() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Sorted(maps.Keys(w.watched))
+}
+
+func (w *fakeWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.events)
+		close(w.errors)
+	})
+	return nil
+}
+
+func TestIsBuildFailure(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	tests := []struct {
+		name   string
+		err    error
+		stdout string
+		stderr string
+		want   bool
+	}{
+		{
+			name: "compile error",
+			err:  exitErr,
+			stdout: `FAIL	example.com/calc [build failed]
+FAIL
+`,
+			stderr: `# example.com/calc [example.com/calc.test]
+./calc.go:12:3: undefined: total
+`,
+			want: true,
+		},
+		{
+			name: "compiler error without build failed marker",
+			err:  exitErr,
+			stderr: `# example.com/calc
+./calc.go:12:3: syntax error: unexpected newline
+`,
+			want: true,
+		},
+		{
+			name: "test failure",
+			err:  exitErr,
+			stdout: `=== RUN   TestAdd
+    calc_test.go:12: want 1, got 2
+--- FAIL: TestAdd (0.00s)
+FAIL
+FAIL	example.com/calc	0.002s
+`,
+			want: false,
+		},
+		{
+			name: "failing test logging a compiler error",
+			err:  exitErr,
+			stdout: `=== RUN   TestGenerate
+./generated.go:3:1: syntax error: non-declaration statement outside function body
+    generate_test.go:20: generated code does not compile
+--- FAIL: TestGenerate (0.01s)
+FAIL
+FAIL	example.com/gen	0.012s
+`,
+			want: false,
+		},
+		{
+			name: "vet error",
+			err:  exitErr,
+			stdout: `FAIL	example.com/calc [build failed]
+FAIL
+`,
+			stderr: `# example.com/calc
+# [example.com/calc]
+./calc_test.go:6:39: fmt.Printf format %d has arg "s" of wrong type string
+`,
+			want: true,
+		},
+		{
+			name: "failing test logging build failed",
+			err:  exitErr,
+			stdout: `=== RUN   TestBuild
+    build_test.go:14: FAIL	example.com/app [build failed]
+build failed: does not compile
+--- FAIL: TestBuild (0.02s)
+FAIL
+FAIL	example.com/build	0.031s
+`,
+			stderr: "build failed: example.com/app does not compile\n./main.go:3:1: syntax error\n",
+			want:   false,
+		},
+		{
+			name:   "successful run mentioning a compile error",
+			err:    nil,
+			stdout: "ok  \texample.com/calc\t0.002s\n./calc.go:12:3: looks like a compile error\n",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBuildFailure(tt.err, tt.stdout, tt.stderr); got != tt.want {
+				t.Errorf("isBuildFailure() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloseStopsWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/calc\n")
+	writeFile(t, filepath.Join(dir, "calc_test.go"), "package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {}\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.Watch()
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after Close")
+	}
+
+	// The process is still running, and the watched directory can be changed without running tests
+	os.Remove(filepath.Join(dir, "calc_test.go"))
+}
+
+func TestWatchListHasWatchedDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"calc", ".git"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "calc", "calc.go"), "package calc\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+
+	if err := tw.addWatches(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got := tw.WatchList()
+	slices.Sort(got)
+	want := []string{dir, filepath.Join(dir, "calc")}
+	if !slices.Equal(got, want) {
+		t.Errorf("WatchList() = %v, want %v", got, want)
+	}
+}
+
+func TestTestDirsOnlyWatchesDirectoriesWithTests(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"calc", "api", filepath.Join("store", "sql")} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "calc", "calc_test.go"), "package calc\n")
+	writeFile(t, filepath.Join(dir, "api", "api.go"), "package api\n")
+	writeFile(t, filepath.Join(dir, "store", "sql", "sql_test.go"), "package sql\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetTestDirsOnly(true)
+
+	if err := tw.addRootWatches(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root is watched for go.mod changes, and directories below ones without tests are still walked
+	got := tw.WatchList()
+	slices.Sort(got)
+	want := []string{dir, filepath.Join(dir, "calc"), filepath.Join(dir, "store", "sql")}
+	if !slices.Equal(got, want) {
+		t.Errorf("WatchList() = %v, want %v", got, want)
+	}
+}
+
+func TestIncludeVendor(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"calc", filepath.Join("vendor", "example.com", "fork")} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vendored := filepath.Join(dir, "vendor", "example.com", "fork", "fork.go")
+	writeFile(t, vendored, "package fork\n")
+	writeFile(t, filepath.Join(dir, ".gitignore"), "vendor/\n")
+
+	for _, include := range []bool{false, true} {
+		tw, err := NewTestWatcher(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.SetLogger(slog.New(slog.DiscardHandler))
+		tw.SetIncludeVendor(include)
+		if err := tw.addWatches(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		watchesVendor := slices.Contains(tw.WatchList(), filepath.Join(dir, "vendor", "example.com", "fork"))
+		if watchesVendor != include {
+			t.Errorf("include vendor %v: vendor watched = %v, want %v", include, watchesVendor, include)
+		}
+		// A vendored change may affect any package, so it runs all of them
+		if got := tw.shouldTrigger(vendored); got != include || tw.modulesChanged != include {
+			t.Errorf("include vendor %v: shouldTrigger() = %v, modulesChanged = %v, want %v", include, got, tw.modulesChanged, include)
+		}
+		tw.watcher.Close()
+	}
+}
+
+func TestFollowSymlinksSkipsCycles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges")
+	}
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	shared := filepath.Join(dir, "shared")
+	for _, sub := range []string{root, shared} {
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(shared, "shared.go"), "package shared\n")
+	// A symlinked package, and a cycle back to the project from inside it
+	if err := os.Symlink(shared, filepath.Join(root, "shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(shared, "project")); err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err := NewTestWatcher(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetFollowSymlinks(true)
+
+	if err := tw.addWatches(root); err != nil {
+		t.Fatal(err)
+	}
+
+	got := tw.WatchList()
+	slices.Sort(got)
+	want := []string{root, filepath.Join(root, "shared")}
+	if !slices.Equal(got, want) {
+		t.Errorf("WatchList() = %v, want %v", got, want)
+	}
+}
+
+func TestDryRunPrintsCommand(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	tw.SetPlainOutput(true)
+	tw.writer.SetOutput(&output)
+	tw.SetTestCommand("sh", []string{"-c", "echo run >> " + calls})
+	tw.SetDryRun(true)
+	tw.SetRunPattern("TestAdd|TestSub")
+	tw.AddChangedFile(filepath.Join(dir, "calc", "calc.go"))
+
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(calls); err == nil {
+		t.Error("the test command ran in dry-run mode")
+	}
+	want := "DRY RUN: tests are not run\n" +
+		"Changed files: " + filepath.Join("calc", "calc.go") + "\n" +
+		"Packages: ./calc\n" +
+		"In " + dir + ":\n" +
+		"  sh -c \"echo run >> " + calls + "\" -v -run \"TestAdd|TestSub\" ./calc\n"
+	if got := output.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDryRunAfterModuleChangeTestsAllPackages(t *testing.T) {
+	dir := t.TempDir()
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	var output strings.Builder
+	tw.SetPlainOutput(true)
+	tw.writer.SetOutput(&output)
+	tw.SetDryRun(true)
+
+	goMod := filepath.Join(dir, "go.mod")
+	if !tw.shouldTrigger(goMod) {
+		t.Fatal("go.mod change does not trigger a run")
+	}
+	tw.AddChangedFile(goMod)
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	want := "DRY RUN: tests are not run\n" +
+		"Changed files: go.mod\n" +
+		"Module requirements changed. Running all tests.\n" +
+		"Packages: ./...\n" +
+		"In " + dir + ":\n" +
+		"  go test -v ./...\n"
+	if got := output.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Like a run, the dry run tested everything, so later changes are tested incrementally again
+	output.Reset()
+	tw.AddChangedFile(filepath.Join(dir, "calc", "calc.go"))
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); !strings.Contains(got, "Packages: ./calc\n") {
+		t.Errorf("output:\n%s\nwant it to test ./calc", got)
+	}
+}
+
+func TestDockerWrapsTestCommand(t *testing.T) {
+	tw := &TestWatcher{testCommand: "go", dockerImage: "golang:1.24"}
+
+	name, args := tw.processCommand("/src/calc", "go-test-watcher-1-1", []string{"test", "-v", "./..."})
+	if got, want := commandLine(name, args), "docker run --rm --init --name go-test-watcher-1-1 -v /src/calc:/app -w /app golang:1.24 go test -v ./..."; got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+
+	tw.coverProfile = "/tmp/calc.coverprofile"
+	name, args = tw.processCommand("/src/calc", "", []string{"test", "-coverprofile=/tmp/calc.coverprofile", "./..."})
+	profileDir := filepath.Dir(tw.coverProfile)
+	want := "docker run --rm --init -v /src/calc:/app -w /app -v " + profileDir + ":" + profileDir + " golang:1.24 go test -coverprofile=/tmp/calc.coverprofile ./..."
+	if got := commandLine(name, args); got != want {
+		t.Errorf("command with a coverage profile = %q, want %q", got, want)
+	}
+	tw.coverProfile = ""
+	tw.SetEnv(map[string]string{"TEST_DB_URL": "postgres://localhost/test", "CGO_ENABLED": "0"})
+	name, args = tw.processCommand("/src/calc", "", []string{"test", "./..."})
+	want = "docker run --rm --init -v /src/calc:/app -w /app -e CGO_ENABLED=0 -e TEST_DB_URL=postgres://localhost/test golang:1.24 go test ./..."
+	if got := commandLine(name, args); got != want {
+		t.Errorf("command with environment variables = %q, want %q", got, want)
+	}
+}
+
+func TestDockerContainerIsKilledOnCancel(t *testing.T) {
+	// docker run keeps running until it is interrupted, like a long test
+	docker, calls := fakeGo(t, recordCalls+`[ "$1" = run ] && exec sleep 10
+exit 0
+`)
+	if err := os.Rename(docker, filepath.Join(filepath.Dir(docker), "docker")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Dir(docker)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetKillGracePeriod(100 * time.Millisecond)
+	if err := tw.SetDockerImage("golang:1.24"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.RunTests()
+	}()
+	run := waitForCalls(t, calls, 1)
+	tw.terminateTests()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunTests() = %v, want %v", err, context.Canceled)
+	}
+
+	// Killing the docker client leaves the container running, so it is stopped by name
+	fields := strings.Fields(run)
+	index := slices.Index(fields, "--name")
+	if index < 0 || index+1 >= len(fields) {
+		t.Fatalf("docker run without a container name: %s", run)
+	}
+	want := run + "kill --signal=INT " + fields[index+1] + "\n"
+	if got := waitForCalls(t, calls, 2); !strings.HasPrefix(got, want) {
+		t.Errorf("docker calls:\n%s\nwant them to start with:\n%s", got, strings.TrimSpace(want))
+	}
+}
+
+// recordCalls is a fake go command script recording the arguments of each call
+const recordCalls = `echo "$*" >> "$calls"` + "\n"
+
+// fakeGo installs script as the go command, with $calls set to the file to record its calls in,
+// and returns the paths of the command and of that file. Tests using it are skipped on windows.
+func fakeGo(t *testing.T, script string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+
+	dir := t.TempDir()
+	goBinary := filepath.Join(dir, "go")
+	calls := filepath.Join(dir, "calls")
+	writeFile(t, goBinary, "#!/bin/sh\ncalls='"+calls+"'\n"+script)
+	if err := os.Chmod(goBinary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return goBinary, calls
+}
+
+// waitForCalls waits until the fake go command has recorded n calls, for runs started in the
+// background, and returns the recorded calls
+func waitForCalls(t *testing.T, calls string, n int) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(calls)
+		if strings.Count(string(data), "\n") >= n {
+			return string(data)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("go command calls:\n%s\nwant %d calls", data, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGoBinaryHonorsGOFLAGS(t *testing.T) {
+	goBinary, calls := fakeGo(t, `echo "$GOFLAGS $*" >> "$calls"`+"\n")
+	dir := t.TempDir()
+	t.Setenv("GOFLAGS", "-mod=vendor")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+
+	if err := tw.RefreshDependencies(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "-mod=vendor list -e -json ./...\n-mod=vendor test -v ./...\n"
+	if got := waitForCalls(t, calls, 2); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+}
+
+func TestWatchRunsTestsForInjectedEvents(t *testing.T) {
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "calc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	calc := filepath.Join(dir, "calc", "calc.go")
+	writeFile(t, calc, "package calc\n")
+
+	fw := newFakeWatcher()
+	tw, err := NewTestWatcherWithWatcher(dir, fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetDebounceDelay(10 * time.Millisecond)
+	tw.SetSkipInitialRun(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.WatchContext(ctx)
+	}()
+
+	// The send blocks until Watch is receiving, after the watches were added
+	fw.events <- fsnotify.Event{Name: calc, Op: fsnotify.Write}
+	if !slices.Contains(fw.WatchList(), filepath.Join(dir, "calc")) {
+		t.Errorf("WatchList() = %v, want it to contain the calc directory", fw.WatchList())
+	}
+
+	want := "list -e -json ./...\ntest -v ./calc\n"
+	if got := waitForCalls(t, calls, 2); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchContext() = %v, want nil", err)
+	}
+}
+
+func TestAlwaysRunAllTestsAllPackages(t *testing.T) {
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "calc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	calc := filepath.Join(dir, "calc", "calc.go")
+	writeFile(t, calc, "package calc\n")
+
+	fw := newFakeWatcher()
+	tw, err := NewTestWatcherWithWatcher(dir, fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetDebounceDelay(10 * time.Millisecond)
+	tw.SetSkipInitialRun(true)
+	tw.SetAlwaysRunAll(true)
+	tw.EnableRace(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.WatchContext(ctx)
+	}()
+
+	// No dependency graph is listed, and the change runs every package with the usual flags
+	fw.events <- fsnotify.Event{Name: calc, Op: fsnotify.Write}
+	want := "test -v -race ./...\n"
+	if got := waitForCalls(t, calls, 1); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchContext() = %v, want nil", err)
+	}
+}
+
+// failingCalcScript is a fake go command script listing the calc package of dir, whose TestAdd fails
+func failingCalcScript(dir string) string {
+	return recordCalls + `case "$1" in
+list) printf '{"Dir":"` + filepath.Join(dir, "calc") + `","ImportPath":"example.com/calc"}\n' ;;
+test) printf -- '--- FAIL: TestAdd (0.00s)\nFAIL\nFAIL\texample.com/calc\t0.01s\n'; exit 1 ;;
+esac
+`
+}
+
+func TestAlwaysRunAllTracksFailedTests(t *testing.T) {
+	dir := t.TempDir()
+	goBinary, calls := fakeGo(t, failingCalcScript(dir))
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetAlwaysRunAll(true)
+
+	if err := tw.RunTests(); err == nil {
+		t.Fatal("RunTests() = nil, want the error of the failing run")
+	}
+	if got, want := tw.failedTestList(), []string{"calc/TestAdd"}; !slices.Equal(got, want) {
+		t.Fatalf("failed tests = %v, want %v", got, want)
+	}
+
+	// Without a dependency graph, the packages are listed to find where the failed tests are
+	if err := tw.rerunFailedTests(); err == nil {
+		t.Fatal("rerunFailedTests() = nil, want the error of the failing run")
+	}
+	want := "test -v ./...\nlist -e -json ./...\ntest -v -run ^(TestAdd)$ ./calc\n"
+	if got := waitForCalls(t, calls, 3); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+}
+
+func TestChangedFilesConcurrentAccess(t *testing.T) {
+	dir := t.TempDir()
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+
+	// Changes are collected by the event loop and debounce timer while a run reads and clears them,
+	// which go test -race reports if the access is not synchronized
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				tw.AddChangedFile(filepath.Join(dir, "calc", "calc.go"))
+				tw.TrackFailedTest(fmt.Sprintf("calc/Test%d", j))
+				if i == 0 {
+					tw.BuildTestArgs()
+					tw.ClearChangedFiles()
+					tw.ClearFailedTests()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tw.ClearFailedTests()
+	tw.AddChangedFile(filepath.Join(dir, "calc", "calc.go"))
+	if got, want := tw.BuildTestArgs(), []string{"test", "-v", "./calc"}; !slices.Equal(got, want) {
+		t.Errorf("BuildTestArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestCollectedStateConcurrentAccess(t *testing.T) {
+	goBinary, _ := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "calc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	calc := filepath.Join(dir, "calc", "calc.go")
+	writeFile(t, calc, "package calc\n")
+	goMod := filepath.Join(dir, "go.mod")
+	writeFile(t, goMod, "module example.com/calc\n")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetWatchEmbeds(true)
+
+	// The debounce timer collects changes, marking the dependency graph stale, while a run refreshes
+	// the graph and selects packages, which go test -race reports if they use different locks
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			tw.pending.add(fsnotify.Event{Name: calc, Op: fsnotify.Write})
+			tw.pending.add(fsnotify.Event{Name: goMod, Op: fsnotify.Write})
+			tw.collectChanges()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			tw.refreshStaleDependencies()
+			tw.selectPackages()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRerunFailedTestsPerPackage(t *testing.T) {
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	for _, test := range []string{"calc/TestAdd", "calc/TestSub", "api/TestGet", "store/TestSub", "store/TestAdd"} {
+		tw.TrackFailedTest(test)
+	}
+
+	if err := tw.rerunFailedTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each package only runs its own failed tests, and packages with the same ones share a run
+	want := "test -v -run ^(TestAdd|TestSub)$ ./calc ./store\ntest -v -run ^(TestGet)$ ./api\n"
+	if got := waitForCalls(t, calls, 2); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+}
+
+func TestPauseHoldsChangesUntilResume(t *testing.T) {
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+	for _, pkg := range []string{"calc", "api"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, pkg, pkg+".go"), "package "+pkg+"\n")
+	}
+
+	fw := newFakeWatcher()
+	tw, err := NewTestWatcherWithWatcher(dir, fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	tw.SetDebounceDelay(10 * time.Millisecond)
+	tw.SetSkipInitialRun(true)
+	tw.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.WatchContext(ctx)
+	}()
+
+	fw.events <- fsnotify.Event{Name: filepath.Join(dir, "calc", "calc.go"), Op: fsnotify.Write}
+	fw.events <- fsnotify.Event{Name: filepath.Join(dir, "api", "api.go"), Op: fsnotify.Write}
+	time.Sleep(100 * time.Millisecond)
+	if data, _ := os.ReadFile(calls); strings.Contains(string(data), "test ") {
+		t.Fatalf("tests ran while paused: %s", data)
+	}
+
+	// Both changes are tested in a single run
+	tw.Resume()
+	want := "list -e -json ./...\ntest -v ./api ./calc\n"
+	if got := waitForCalls(t, calls, 2); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchContext() = %v, want nil", err)
+	}
+}
+
+func TestBuildFailureIsRetried(t *testing.T) {
+	// The first run reads a half-saved file
+	goBinary, calls := fakeGo(t, `if [ ! -f "$calls" ]; then
+	echo "$*" >> "$calls"
+	echo "./calc.go:3:1: syntax error: unexpected EOF" >&2
+	printf "FAIL\texample.com/calc [build failed]\n"
+	exit 1
+fi
+echo "$*" >> "$calls"
+printf "ok  \texample.com/calc\t0.01s\n"
+`)
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+	tw.SetBuildRetries(1, time.Millisecond)
+
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if tw.lastResult.Outcome != OutcomePassed {
+		t.Errorf("outcome = %s, want %s", tw.lastResult.Outcome, OutcomePassed)
+	}
+	if got := strings.Count(waitForCalls(t, calls, 2), "\n"); got != 2 {
+		t.Errorf("go test ran %d times, want 2", got)
+	}
+}
+
+func TestCancelBetweenProcessesOfARun(t *testing.T) {
+	// Every run fails to build, and the retry waits long after the first process has exited
+	goBinary, calls := fakeGo(t, recordCalls+`printf "FAIL\texample.com/calc [build failed]\n"
+touch "$calls.exited"
+exit 1
+`)
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+	tw.SetBuildRetries(1, time.Hour)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tw.RunTests()
+	}()
+	waitForCalls(t, calls, 1)
+	for {
+		if _, err := os.Stat(calls + ".exited"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// No process is running, but the run is, so it is cancelled without starting the retry
+	tw.terminateTests()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RunTests() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run was not cancelled between its processes")
+	}
+	if got := strings.Count(waitForCalls(t, calls, 1), "\n"); got != 1 {
+		t.Errorf("go test ran %d times, want 1", got)
+	}
+}
+
+func TestGitChangedSeedsFirstRun(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// The calls are recorded outside the repository, where they aren't changes in git
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+	for _, pkg := range []string{"calc", "api"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, pkg, pkg+".go"), "package "+pkg+"\n")
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+	tw.SetGitChanged(true)
+
+	// Nothing changed yet, so nothing is tested
+	if _, err := tw.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(calls); strings.Contains(string(data), "test ") {
+		t.Errorf("tests ran without changes in git: %s", data)
+	}
+	os.Remove(calls)
+
+	writeFile(t, filepath.Join(dir, "calc", "calc.go"), "package calc\n\nfunc Add() {}\n")
+	if _, err := tw.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := waitForCalls(t, calls, 2), "list -e -json ./...\ntest -v ./calc\n"; got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+}
+
+func TestModuleFileChangeRunsAllTests(t *testing.T) {
+	goBinary, calls := fakeGo(t, recordCalls)
+	dir := t.TempDir()
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.writer.SetOutput(io.Discard)
+	tw.SetGoBinary(goBinary)
+
+	goMod := filepath.Join(dir, "go.mod")
+	if !tw.shouldTrigger(goMod) {
+		t.Fatal("go.mod change does not trigger a run")
+	}
+	tw.AddChangedFile(goMod)
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Later changes are tested incrementally again
+	tw.AddChangedFile(filepath.Join(dir, "calc.go"))
+	if err := tw.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "test -v ./...\ntest -v .\n"
+	if got := waitForCalls(t, calls, 2); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+	if !tw.dependenciesStale {
+		t.Error("go.mod change did not mark the dependency graph stale")
+	}
+}
+
+func TestFocusRestrictsSelection(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "internal", "calc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+
+	if err := tw.SetFocus("./missing"); err == nil {
+		t.Error("SetFocus accepted a missing directory")
+	}
+	if err := tw.SetFocus("./internal/calc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if tw.shouldTrigger(filepath.Join(dir, "main.go")) {
+		t.Error("change outside the focused package triggers a run")
+	}
+	if !tw.shouldTrigger(filepath.Join(dir, "internal", "calc", "add.go")) {
+		t.Error("change in the focused package does not trigger a run")
+	}
+
+	tw.packageDependencies = map[string][]string{"internal/calc": {"."}}
+	tw.AddChangedFile(filepath.Join(dir, "internal", "calc", "add.go"))
+	if got := tw.packagesToTest(); !slices.Equal(got, []string{"./internal/calc"}) {
+		t.Errorf("packagesToTest() = %q, want [./internal/calc]", got)
+	}
+}
+
+func TestFocusTracksFailedTests(t *testing.T) {
+	dir := t.TempDir()
+	goBinary, calls := fakeGo(t, failingCalcScript(dir))
+	if err := os.Mkdir(filepath.Join(dir, "calc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err := NewTestWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tw.watcher.Close()
+	tw.writer.SetOutput(io.Discard)
+	tw.SetLogger(slog.New(slog.DiscardHandler))
+	tw.SetGoBinary(goBinary)
+	if err := tw.SetFocus("./calc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.RunTests(); err == nil {
+		t.Fatal("RunTests() = nil, want the error of the failing run")
+	}
+	if got, want := tw.failedTestList(), []string{"calc/TestAdd"}; !slices.Equal(got, want) {
+		t.Fatalf("failed tests = %v, want %v", got, want)
+	}
+
+	if err := tw.rerunFailedTests(); err == nil {
+		t.Fatal("rerunFailedTests() = nil, want the error of the failing run")
+	}
+	want := "test -v ./calc\nlist -e -json ./...\ntest -v -run ^(TestAdd)$ ./calc\n"
+	if got := waitForCalls(t, calls, 3); got != want {
+		t.Errorf("go command calls:\n%s\nwant:\n%s", got, strings.TrimSpace(want))
+	}
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// watchBatchSize is how many directories are added to the file watcher between progress reports on startup
+const watchBatchSize = 500
+
+// SetTestDirsOnly makes the watcher only watch the directories that contain _test.go files on startup,
+// besides the roots, to start faster and use fewer watches in repositories with thousands of directories.
+// Changes to packages without tests then go unnoticed. Directories created while watching are watched as usual.
+func (tw *TestWatcher) SetTestDirsOnly(enabled bool) {
+	tw.testDirsOnly = enabled
+}
+
+// addRootWatches watches the directories of every root. The directories are found first and then
+// added in batches, reporting the progress for large repositories and the number watched at the end.
+func (tw *TestWatcher) addRootWatches() error {
+	var dirs []string
+	for _, root := range tw.roots {
+		err := tw.walkWatches(root, root, make(map[string]bool), func(path, walked string) error {
+			if !tw.testDirsOnly || path == root || hasTestFiles(walked) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(dirs); start += watchBatchSize {
+		batch := dirs[start:min(start+watchBatchSize, len(dirs))]
+		for _, dir := range batch {
+			if err := tw.addWatch(dir); err != nil {
+				return err
+			}
+		}
+		if len(dirs) > watchBatchSize {
+			fmt.Printf("Watching directories: %d/%d\n", start+len(batch), len(dirs))
+		}
+	}
+
+	if tw.testDirsOnly {
+		fmt.Printf("Watching %d directories (only those with tests).\n", len(dirs))
+	} else {
+		fmt.Printf("Watching %d directories.\n", len(dirs))
+	}
+	return nil
+}
+
+// hasTestFiles reports whether dir directly contains _test.go files
+func hasTestFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), "_test.go") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds a webhook request so a slow endpoint can't pile up requests
+const webhookTimeout = 5 * time.Second
+
+// webhookPayload is the JSON body posted to the webhook: the RunReport of the run, and a text
+// summary that chat services such as Slack display
+type webhookPayload struct {
+	RunReport
+	Text string `json:"text"`
+}
+
+// SetWebhook sets a URL to POST a JSON payload to when the tests start or stop failing.
+// The payload is the RunReport of the run with a "text" summary added.
+func (tw *TestWatcher) SetWebhook(url string) {
+	tw.webhookURL = url
+}
+
+// postWebhook posts the payload for result to the webhook in the background, logging failures.
+// Like the outcome hooks, Close waits for the request to finish.
+func (tw *TestWatcher) postWebhook(result Result) {
+	title, message := transitionMessage(result)
+	payload := webhookPayload{
+		RunReport: tw.newRunReport(result),
+		Text:      fmt.Sprintf("%s: %s", title, message),
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		tw.logger.Warn("could not encode webhook payload", "err", err)
+		return
+	}
+
+	tw.hooks.Add(1)
+	go func() {
+		defer tw.hooks.Done()
+		client := http.Client{Timeout: webhookTimeout}
+		response, err := client.Post(tw.webhookURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			tw.logger.Warn("webhook failed", "err", err)
+			return
+		}
+		response.Body.Close()
+		if response.StatusCode >= 300 {
+			tw.logger.Warn("webhook failed", "status", response.Status)
+		}
+	}()
+}
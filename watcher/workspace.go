@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// workspaceFile is the file that makes a root a Go workspace of several modules
+const workspaceFile = "go.work"
+
+// rootPatterns returns the package patterns matching every package of a root. In a Go workspace
+// "./..." matches no module, so each module of the workspace is matched by its module path instead,
+// such as "example.com/api/...".
+func (tw *TestWatcher) rootPatterns(root string) []string {
+	if modules := tw.workspaceModules(root); len(modules) > 0 {
+		patterns := make([]string, len(modules))
+		for i, module := range modules {
+			patterns[i] = module + "/..."
+		}
+		return patterns
+	}
+	return []string{"./..."}
+}
+
+// workspaceModules returns the module paths of the modules used by the go.work file of root,
+// or nil when root is not a workspace or workspace mode is turned off with GOWORK=off.
+// The file is read on every call so edits to it apply to the next run.
+func (tw *TestWatcher) workspaceModules(root string) []string {
+	gowork, ok := tw.env["GOWORK"]
+	if !ok {
+		gowork = os.Getenv("GOWORK")
+	}
+	if gowork == "off" {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, workspaceFile))
+	if err != nil {
+		return nil
+	}
+
+	var modules []string
+	for _, dir := range parseWorkspaceUses(data) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		modfile, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			// go test reports the missing module itself
+			continue
+		}
+		if module := parseModulePath(modfile); module != "" {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// parseWorkspaceUses returns the module directories of the use directives of a go.work file,
+// both as single lines and as parenthesized blocks
+func parseWorkspaceUses(data []byte) []string {
+	var dirs []string
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "//"); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+
+		if inBlock {
+			if line == ")" {
+				inBlock = false
+			} else if line != "" {
+				dirs = append(dirs, unquoteDirective(line))
+			}
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(line, "use")
+		if !ok || rest == "" || !strings.ContainsRune(" \t(", rune(rest[0])) {
+			continue
+		}
+		if arg := strings.TrimSpace(rest); arg == "(" {
+			inBlock = true
+		} else {
+			dirs = append(dirs, unquoteDirective(arg))
+		}
+	}
+	return dirs
+}
+
+// parseModulePath returns the module path declared by a go.mod file, or "" if it has none
+func parseModulePath(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if module, ok := strings.CutPrefix(line, "module "); ok {
+			if index := strings.Index(module, "//"); index >= 0 {
+				module = module[:index]
+			}
+			return unquoteDirective(strings.TrimSpace(module))
+		}
+	}
+	return ""
+}
+
+// unquoteDirective returns the argument of a go.mod or go.work directive, which may be quoted
+func unquoteDirective(arg string) string {
+	if unquoted, err := strconv.Unquote(arg); err == nil {
+		return unquoted
+	}
+	return arg
+}